@@ -30,7 +30,7 @@ type Address struct {
 
 func attrAddressFamily(attr []byte) byte { return attr[1] }
 
-func (a *Address) Unmarshal(raw []byte, attrType attr, attrValue []byte) error {
+func (a *Address) Unmarshal(raw []byte, attrType Attr, attrValue []byte) error {
 	if len(attrValue) < 4+net.IPv4len {
 		return ErrUnexpectedEOF
 	}
@@ -41,28 +41,25 @@ func (a *Address) Unmarshal(raw []byte, attrType attr, attrValue []byte) error {
 		return ErrUnknownIPFamily
 	}
 	if len(attrValue) != 4+n {
-		return ErrUnexpectedEOF
-	}
-	if attributeSize(attrValue) != n {
 		return ErrMalformedAttribute
 	}
 	a.IP = a.buf[:n] // make([]byte, n)
 	copy(a.IP, attrValue[4:])
 	a.Port = binary.BigEndian.Uint16(attrValue[2:4])
 	switch attrType {
-	case attrXorMappedAddress:
+	case AttrXorMappedAddress:
 		for i, x := range raw[4 : 4+n] { // raw[4:4+n] spans magiccookie and transaction id if needed
 			a.IP[i] ^= x
 		}
 		a.Port ^= magicCookiePort
 		return nil
-	case attrMappedAddress, attrAlternateServer:
+	case AttrMappedAddress, AttrAlternateServer, AttrResponseOrigin, AttrOtherAddress:
 		return nil
 	}
 	return ErrUnknownAddressAttribute
 }
 
-func appendAddress(m []byte, a attr, ip net.IP, port uint16) []byte {
+func appendAddress(m []byte, a Attr, ip net.IP, port uint16) []byte {
 	n := len(ip)
 	m = append(m, byte(a>>8), byte(a),
 		0, byte(4+n), 0, family(n), byte(port>>8), byte(port))
@@ -70,13 +67,13 @@ func appendAddress(m []byte, a attr, ip net.IP, port uint16) []byte {
 }
 
 func appendMappedAddress(m []byte, ip net.IP, port uint16) []byte {
-	return appendAddress(m, attrMappedAddress, ip, port)
+	return appendAddress(m, AttrMappedAddress, ip, port)
 }
 
 func appendXorMappedAddress(m []byte, ip net.IP, port uint16) []byte {
 	port ^= magicCookiePort
 	n := len(ip)
-	m = append(m, byte(attrXorMappedAddress>>8), byte(attrXorMappedAddress),
+	m = append(m, byte(AttrXorMappedAddress>>8), byte(AttrXorMappedAddress),
 		0, byte(4+n), 0, family(n), byte(port>>8), byte(port))
 	m = append(m, m[4:4+n]...) // m[4:4+n] spans magiccookie and transaction id if needed
 	s := m[len(m)-n:]
@@ -87,5 +84,13 @@ func appendXorMappedAddress(m []byte, ip net.IP, port uint16) []byte {
 }
 
 func appendAlternateServer(m []byte, ip net.IP, port uint16) []byte {
-	return appendAddress(m, attrAlternateServer, ip, port)
+	return appendAddress(m, AttrAlternateServer, ip, port)
+}
+
+func appendResponseOrigin(m []byte, ip net.IP, port uint16) []byte {
+	return appendAddress(m, AttrResponseOrigin, ip, port)
+}
+
+func appendOtherAddress(m []byte, ip net.IP, port uint16) []byte {
+	return appendAddress(m, AttrOtherAddress, ip, port)
 }
@@ -2,6 +2,7 @@ package stun
 
 import (
 	"crypto/sha256"
+	"strconv"
 )
 
 /*
@@ -9,40 +10,95 @@ import (
 	These do not apply any validation to inputs, so can be used to generate malformed messages for testing Parse().
 */
 
-type attr uint16
+// Attr is a STUN attribute type.
+// See https://tools.ietf.org/html/rfc8489#section-18.3
+type Attr uint16
+
+var attrNames = map[Attr]string{
+	AttrMappedAddress:          "MAPPED-ADDRESS",
+	AttrChangeRequest:          "CHANGE-REQUEST",
+	AttrUsername:               "USERNAME",
+	AttrMessageIntegrity:       "MESSAGE-INTEGRITY",
+	AttrErrorCode:              "ERROR-CODE",
+	AttrUnknownAttributes:      "UNKNOWN-ATTRIBUTES",
+	AttrChannelNumber:          "CHANNEL-NUMBER",
+	AttrLifeTime:               "LIFETIME",
+	AttrXorPeerAddress:         "XOR-PEER-ADDRESS",
+	AttrData:                   "DATA",
+	AttrRealm:                  "REALM",
+	AttrNonce:                  "NONCE",
+	AttrXorRelayedAddress:      "XOR-RELAYED-ADDRESS",
+	AttrRequestedAddressFamily: "REQUESTED-ADDRESS-FAMILY",
+	AttrMessageIntegritySHA256: "MESSAGE-INTEGRITY-SHA256",
+	AttrPasswordAlgorithm:      "PASSWORD-ALGORITHM",
+	AttrUserHash:               "USERHASH",
+	AttrXorMappedAddress:       "XOR-MAPPED-ADDRESS",
+	AttrReservationToken:       "RESERVATION-TOKEN",
+	AttrPriority:               "PRIORITY",
+	AttrUseCandidate:           "USE-CANDIDATE",
+	AttrPadding:                "PADDING",
+	AttrResponsePort:           "RESPONSE-PORT",
+	AttrConnectionID:           "CONNECTION-ID",
+	AttrPasswordAlgorithms:     "PASSWORD-ALGORITHMS",
+	AttrAlternateDomain:        "ALTERNATE-DOMAIN",
+	AttrSoftware:               "SOFTWARE",
+	AttrAlternateServer:        "ALTERNATE-SERVER",
+	AttrFingerprint:            "FINGERPRINT",
+	AttrICEControlled:          "ICE-CONTROLLED",
+	AttrICEControlling:         "ICE-CONTROLLING",
+	AttrResponseOrigin:         "RESPONSE-ORIGIN",
+	AttrOtherAddress:           "OTHER-ADDRESS",
+}
+
+// String returns the attribute name as used in the RFCs, or its hexadecimal value if unknown.
+func (a Attr) String() string {
+	if s, ok := attrNames[a]; ok {
+		return s
+	}
+	return "0x" + strconv.FormatUint(uint64(a), 16)
+}
+
+// ComprehensionRequired returns true for attribute types in the comprehension-required range 0x0000-0x7FFF.
+// See https://tools.ietf.org/html/rfc8489#section-14
+func (a Attr) ComprehensionRequired() bool { return a < 0x8000 }
 
+// Attribute types, for use with Attributes, Parser.SetComprehendedAttributes and Builder.SetUnknownAttributes.
+// See https://tools.ietf.org/html/rfc8489#section-18.3 & https://tools.ietf.org/html/rfc5780#section-9
 const (
-	attrMappedAddress          attr = 0x0001
-	attrUsername               attr = 0x0006
-	attrMessageIntegrity       attr = 0x0008
-	attrErrorCode              attr = 0x0009
-	attrUnknownAttributes      attr = 0x000A
-	attrChannelNumber          attr = 0x000C
-	attrLifeTime               attr = 0x000D
-	attrXorPeerAddress         attr = 0x0012
-	attrData                   attr = 0x0013
-	attrRealm                  attr = 0x0014
-	attrNonce                  attr = 0x0015
-	attrXorRelayedAddress      attr = 0x0016
-	attrRequestedAddressFamily attr = 0x0017
-	attrMessageIntegritySHA256 attr = 0x001C
-	attrPasswordAlgorithm      attr = 0x001D
-	attrUserHash               attr = 0x001E
-	attrXorMappedAddress       attr = 0x0020
-	attrReservationToken       attr = 0x0022
-	attrPriority               attr = 0x0024
-	attrUseCandidate           attr = 0x0025
-	attrPadding                attr = 0x0026
-	attrResponsePort           attr = 0x0027
-	attrConnectionID           attr = 0x002A
-
-	attrPasswordAlgorithms attr = 0x8002
-	attrAlternateDomain    attr = 0x8003
-	attrSoftware           attr = 0x8022
-	attrAlternateServer    attr = 0x8023
-	attrFingerprint        attr = 0x8028
-	attrICEControlled      attr = 0x8029
-	attrICEControlling     attr = 0x802A
+	AttrMappedAddress          Attr = 0x0001
+	AttrChangeRequest          Attr = 0x0003
+	AttrUsername               Attr = 0x0006
+	AttrMessageIntegrity       Attr = 0x0008
+	AttrErrorCode              Attr = 0x0009
+	AttrUnknownAttributes      Attr = 0x000A
+	AttrChannelNumber          Attr = 0x000C
+	AttrLifeTime               Attr = 0x000D
+	AttrXorPeerAddress         Attr = 0x0012
+	AttrData                   Attr = 0x0013
+	AttrRealm                  Attr = 0x0014
+	AttrNonce                  Attr = 0x0015
+	AttrXorRelayedAddress      Attr = 0x0016
+	AttrRequestedAddressFamily Attr = 0x0017
+	AttrMessageIntegritySHA256 Attr = 0x001C
+	AttrPasswordAlgorithm      Attr = 0x001D
+	AttrUserHash               Attr = 0x001E
+	AttrXorMappedAddress       Attr = 0x0020
+	AttrReservationToken       Attr = 0x0022
+	AttrPriority               Attr = 0x0024
+	AttrUseCandidate           Attr = 0x0025
+	AttrPadding                Attr = 0x0026
+	AttrResponsePort           Attr = 0x0027
+	AttrConnectionID           Attr = 0x002A
+
+	AttrPasswordAlgorithms Attr = 0x8002
+	AttrAlternateDomain    Attr = 0x8003
+	AttrSoftware           Attr = 0x8022
+	AttrAlternateServer    Attr = 0x8023
+	AttrFingerprint        Attr = 0x8028
+	AttrICEControlled      Attr = 0x8029
+	AttrICEControlling     Attr = 0x802A
+	AttrResponseOrigin     Attr = 0x802B
+	AttrOtherAddress       Attr = 0x802C
 )
 
 type PasswordAlgorithm uint16
@@ -52,6 +108,16 @@ const (
 	PasswordAlgorithmSHA256 PasswordAlgorithm = 0x0002
 )
 
+func (a PasswordAlgorithm) String() string {
+	switch a {
+	case PasswordAlgorithmMD5:
+		return "MD5"
+	case PasswordAlgorithmSHA256:
+		return "SHA-256"
+	}
+	return "0x" + strconv.FormatUint(uint64(a), 16)
+}
+
 var zeroPad [4]byte
 
 func newHeader(buf []byte, t Type, txID [12]byte) []byte {
@@ -59,7 +125,7 @@ func newHeader(buf []byte, t Type, txID [12]byte) []byte {
 	return append(m, txID[:]...)
 }
 
-func appendAttribute(m []byte, a attr, b []byte) []byte {
+func appendAttribute(m []byte, a Attr, b []byte) []byte {
 	n := len(b)
 	m = append(m, byte(a>>8), byte(a), byte(n>>8), byte(n))
 	m = append(m, b...)
@@ -69,7 +135,7 @@ func appendAttribute(m []byte, a attr, b []byte) []byte {
 	return m
 }
 
-func appendAttributeString(m []byte, a attr, s string) []byte {
+func appendAttributeString(m []byte, a Attr, s string) []byte {
 	n := len(s)
 	m = append(m, byte(a>>8), byte(a), byte(n>>8), byte(n))
 	m = append(m, s...)
@@ -79,28 +145,51 @@ func appendAttributeString(m []byte, a attr, s string) []byte {
 	return m
 }
 
-func appendAttributeUint32(m []byte, a attr, x uint32) []byte {
+func appendAttributeUint32(m []byte, a Attr, x uint32) []byte {
 	return append(m, byte(a>>8), byte(a), 0, 4, byte(x>>24), byte(x>>16), byte(x>>8), byte(x))
 }
 
-func appendAttributeUint64(m []byte, a attr, x uint64) []byte {
+func appendAttributeUint64(m []byte, a Attr, x uint64) []byte {
 	return append(m, byte(a>>8), byte(a), 0, 8, byte(x>>56), byte(x>>48), byte(x>>40), byte(x>>32), byte(x>>24), byte(x>>16), byte(x>>8), byte(x))
 }
 
 func appendUsername(m []byte, username string) []byte {
-	return appendAttributeString(m, attrUsername, username)
+	return appendAttributeString(m, AttrUsername, username)
 }
 
 func appendSoftware(m []byte, software string) []byte {
-	return appendAttributeString(m, attrSoftware, software)
+	return appendAttributeString(m, AttrSoftware, software)
 }
 
 func appendRealm(m []byte, realm string) []byte {
-	return appendAttributeString(m, attrRealm, realm)
+	return appendAttributeString(m, AttrRealm, realm)
 }
 
 func appendNonce(m []byte, nonce []byte) []byte {
-	return appendAttribute(m, attrNonce, nonce)
+	return appendAttribute(m, AttrNonce, nonce)
+}
+
+// CHANGE-REQUEST flags.
+// See https://tools.ietf.org/html/rfc5780#section-7.2
+const (
+	changeIPFlag   = 0x04
+	changePortFlag = 0x02
+)
+
+func appendChangeRequest(m []byte, flags uint32) []byte {
+	return appendAttributeUint32(m, AttrChangeRequest, flags)
+}
+
+func appendResponsePort(m []byte, port uint16) []byte {
+	return appendAttributeUint32(m, AttrResponsePort, uint32(port)<<16)
+}
+
+func appendPadding(m []byte, n int) []byte {
+	m = append(m, byte(AttrPadding>>8), byte(AttrPadding), byte(n>>8), byte(n))
+	for i := 0; i < (n+3)&^3; i++ {
+		m = append(m, 0)
+	}
+	return m
 }
 
 type Features uint32
@@ -110,13 +199,12 @@ const (
 	FeatureUserAnonyminity    Features = 1 << 1
 )
 
-func appendNonceWithSecurityFeatures(m []byte, f Features, nonce []byte) []byte {
-	const b64 = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+const b64 = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
 
+func appendNonceWithSecurityFeatures(m []byte, f Features, nonce []byte) []byte {
 	n := len(nonceSecurityFeaturesPrefix) + 4 + len(nonce)
-	m = append(m, byte(attrNonce>>8), byte(attrNonce), byte(n>>8), byte(n))
-	m = append(m, nonceSecurityFeaturesPrefix...)
-	m = append(m, b64[(f>>18)%64], b64[(f>>12)%64], b64[(f>>6)%64], b64[f%64])
+	m = append(m, byte(AttrNonce>>8), byte(AttrNonce), byte(n>>8), byte(n))
+	m = appendSecurityFeatures(m, f)
 	m = append(m, nonce...)
 	if i := n % 4; i != 0 {
 		return append(m, zeroPad[i:4]...)
@@ -124,6 +212,35 @@ func appendNonceWithSecurityFeatures(m []byte, f Features, nonce []byte) []byte
 	return m
 }
 
+// appendSecurityFeatures appends the nonce cookie followed by 24 bits of Features encoded as 4 base64 characters.
+func appendSecurityFeatures(b []byte, f Features) []byte {
+	b = append(b, nonceSecurityFeaturesPrefix...)
+	return append(b, b64[(f>>18)%64], b64[(f>>12)%64], b64[(f>>6)%64], b64[f%64])
+}
+
+// decodeSecurityFeatures decodes the 4 base64 characters following the nonce cookie into 24 bits of Features.
+func decodeSecurityFeatures(b []byte) (f Features, ok bool) {
+	for _, c := range b[:4] {
+		var x byte
+		switch {
+		case c >= 'A' && c <= 'Z':
+			x = c - 'A'
+		case c >= 'a' && c <= 'z':
+			x = c - 'a' + 26
+		case c >= '0' && c <= '9':
+			x = c - '0' + 52
+		case c == '+':
+			x = 62
+		case c == '/':
+			x = 63
+		default:
+			return 0, false
+		}
+		f = f<<6 | Features(x)
+	}
+	return f, true
+}
+
 func appendUserHash(m []byte, username, realm string) []byte {
 	var buf [64]byte
 
@@ -131,12 +248,10 @@ func appendUserHash(m []byte, username, realm string) []byte {
 	h.Write(append(buf[:0], username...))
 	buf[0] = ':'
 	h.Write(append(buf[:1], realm...))
-	m = append(m, byte(attrUserHash>>8), byte(attrUserHash), byte(sha256.Size>>8), byte(sha256.Size))
+	m = append(m, byte(AttrUserHash>>8), byte(AttrUserHash), byte(sha256.Size>>8), byte(sha256.Size))
 	return h.Sum(m)
 }
 
-//NOT NEEDED go:generate stringer -type ErrorCode -trimprefix ErrorCode
-
 type ErrorCode uint16
 
 const (
@@ -148,10 +263,36 @@ const (
 	ErrorCodeServerErrorRetry ErrorCode = 500
 )
 
+// String returns the error code followed by the reason phrase suggested for it by RFC 8489.
+// See https://tools.ietf.org/html/rfc8489#section-14.8
+func (e ErrorCode) String() string {
+	var reason string
+
+	switch e {
+	case ErrorCodeTryAlternate:
+		reason = "Try Alternate"
+	case ErrorCodeBadRequest:
+		reason = "Bad Request"
+	case ErrorCodeUnauthenticated:
+		reason = "Unauthenticated"
+	case ErrorCodeUnknownAttribute:
+		reason = "Unknown Attribute"
+	case ErrorCodeStaleNonce:
+		reason = "Stale Nonce"
+	case ErrorCodeServerErrorRetry:
+		reason = "Server Error"
+	default:
+		return strconv.Itoa(int(e))
+	}
+	return strconv.Itoa(int(e)) + " " + reason
+}
+
+// appendErrorCode encodes the error code as class (hundreds digit) and number (modulo 100)
+// See https://tools.ietf.org/html/rfc8489#section-14.8
 func appendErrorCode(m []byte, errorCode ErrorCode, reason string) []byte {
 	n := 4 + len(reason)
-	m = append(m, byte(attrErrorCode>>8), byte(attrErrorCode), byte(n>>8), byte(n),
-		0, 0, byte(errorCode>>8), byte(errorCode))
+	m = append(m, byte(AttrErrorCode>>8), byte(AttrErrorCode), byte(n>>8), byte(n),
+		0, 0, byte(errorCode/100), byte(errorCode%100))
 	m = append(m, reason...)
 	if i := n % 4; i != 0 {
 		m = append(m, zeroPad[i:4]...)
@@ -159,10 +300,20 @@ func appendErrorCode(m []byte, errorCode ErrorCode, reason string) []byte {
 	return m
 }
 
+// errorCodeFromAttribute decodes the class and number of an ERROR-CODE attribute value, ok is false if either is
+// out of range.
+func errorCodeFromAttribute(attrValue []byte) (errorCode ErrorCode, ok bool) {
+	class, number := attrValue[2]&0x07, attrValue[3]
+	if class < 3 || class > 6 || number > 99 {
+		return 0, false
+	}
+	return ErrorCode(class)*100 + ErrorCode(number), true
+}
+
 func appendPasswordAlgorithm(m []byte, passwordAlgorithm PasswordAlgorithm, parameters []byte) []byte {
 	p := len(parameters)
 	n := 4 + p
-	m = append(m, byte(attrPasswordAlgorithm>>8), byte(attrPasswordAlgorithm), byte(n>>8), byte(n),
+	m = append(m, byte(AttrPasswordAlgorithm>>8), byte(AttrPasswordAlgorithm), byte(n>>8), byte(n),
 		byte(passwordAlgorithm>>8), byte(passwordAlgorithm), byte(p>>8), byte(p))
 	m = append(m, parameters...)
 	if i := n % 4; i != 0 {
@@ -171,9 +322,37 @@ func appendPasswordAlgorithm(m []byte, passwordAlgorithm PasswordAlgorithm, para
 	return m
 }
 
-func appendUnknownAttributes(m []byte, attributes []uint16) []byte {
+func appendPasswordAlgorithms(m []byte, passwordAlgorithms []PasswordAlgorithm) []byte {
+	a, n := AttrPasswordAlgorithms, 4*len(passwordAlgorithms)
+	m = append(m, byte(a>>8), byte(a), byte(n>>8), byte(n))
+	for _, pa := range passwordAlgorithms {
+		m = append(m, byte(pa>>8), byte(pa), 0, 0)
+	}
+	return m
+}
+
+// validPasswordAlgorithms checks the PASSWORD-ALGORITHMS attribute value is a well formed sequence of algorithms and
+// their padded parameters.
+func validPasswordAlgorithms(attrValue []byte) bool {
+	if len(attrValue) == 0 {
+		return false
+	}
+	for len(attrValue) > 0 {
+		if len(attrValue) < 4 {
+			return false
+		}
+		n := 4 + (attributeSize(attrValue)+3)&^3
+		if len(attrValue) < n {
+			return false
+		}
+		attrValue = attrValue[n:]
+	}
+	return true
+}
+
+func appendUnknownAttributes(m []byte, attributes []Attr) []byte {
 	n := len(attributes) * 2
-	m = append(m, byte(attrUnknownAttributes>>8), byte(attrUnknownAttributes), byte(n>>8), byte(n))
+	m = append(m, byte(AttrUnknownAttributes>>8), byte(AttrUnknownAttributes), byte(n>>8), byte(n))
 	for _, a := range attributes {
 		m = append(m, byte(a>>8), byte(a))
 	}
@@ -184,15 +363,15 @@ func appendUnknownAttributes(m []byte, attributes []uint16) []byte {
 }
 
 func appendAlternateDomain(m []byte, domain string) []byte {
-	return appendAttributeString(m, attrAlternateDomain, domain)
+	return appendAttributeString(m, AttrAlternateDomain, domain)
 }
 
 func appendPriority(m []byte, typePref uint8, localPref uint16, componentID uint8) []byte {
-	return appendAttributeUint32(m, attrPriority, uint32(typePref)<<24|uint32(localPref)<<8|(256-uint32(componentID)))
+	return appendAttributeUint32(m, AttrPriority, uint32(typePref)<<24|uint32(localPref)<<8|(256-uint32(componentID)))
 }
 
 func appendICEControlled(m []byte, iceControlled uint64) []byte {
-	return appendAttributeUint64(m, attrICEControlled, iceControlled)
+	return appendAttributeUint64(m, AttrICEControlled, iceControlled)
 }
 
 //
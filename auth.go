@@ -0,0 +1,102 @@
+package stun
+
+import (
+	"crypto/rand"
+)
+
+// defaultPasswordAlgorithms are advertised in 401 responses if Server.PasswordAlgorithms is empty, in order of
+// preference.
+var defaultPasswordAlgorithms = []PasswordAlgorithm{PasswordAlgorithmSHA256, PasswordAlgorithmMD5}
+
+func (s *Server) passwordAlgorithms() []PasswordAlgorithm {
+	if len(s.PasswordAlgorithms) > 0 {
+		return s.PasswordAlgorithms
+	}
+	return defaultPasswordAlgorithms
+}
+
+// nonceFeatures are the security features advertised in nonces issued by Server.
+const nonceFeatures = FeaturePasswordAlgorithms | FeatureUserAnonyminity
+
+// nonces returns the Server's NonceManager, creating one with a random secret if none was set.
+func (s *Server) nonces() (*NonceManager, error) {
+	if s.Nonces != nil {
+		return s.Nonces, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.randomNonces == nil {
+		var secret [32]byte
+
+		if _, err := rand.Read(secret[:]); err != nil {
+			return nil, err
+		}
+		s.randomNonces = NewNonceManager(secret[:], 0)
+	}
+	return s.randomNonces, nil
+}
+
+// authenticate applies the short term or long term credential mechanism to the request, returning the error response
+// if it fails, or nil to process the request. parseErr is the error returned from parsing the request.
+// See https://tools.ietf.org/html/rfc8489#section-9.1.3 & https://tools.ietf.org/html/rfc8489#section-9.2.4
+func (s *Server) authenticate(r *Request, parseErr error) *Builder {
+	m := r.Message
+	integrity := parseErr != nil || m.key != nil
+	if s.Realm == "" {
+		if !integrity || len(m.username) == 0 {
+			return r.NewErrorResponse(ErrorCodeBadRequest, "Bad Request")
+		}
+		if parseErr != nil {
+			return r.NewErrorResponse(ErrorCodeUnauthenticated, "Unauthenticated")
+		}
+		return nil
+	}
+	if !integrity {
+		return s.unauthenticated(r)
+	}
+	if (len(m.username) == 0 && len(m.userHash) == 0) || len(m.realm) == 0 || len(m.nonce) == 0 {
+		return r.NewErrorResponse(ErrorCodeBadRequest, "Bad Request")
+	}
+	nonces, err := s.nonces()
+	if err != nil {
+		s.logf("stun: nonce secret generation failed: %v", err)
+		return r.NewErrorResponse(ErrorCodeServerErrorRetry, "Server Error")
+	}
+	// Only expired nonces are stale, others were not issued by the Server so the request is unauthenticated
+	if err := nonces.Validate(m.nonce, r.RemoteAddr); err == ErrStaleNonce {
+		b := r.NewErrorResponse(ErrorCodeStaleNonce, "Stale Nonce")
+		s.setChallenge(b, nonces.Nonce(nonceFeatures, r.RemoteAddr))
+		return b
+	} else if err != nil {
+		return s.unauthenticated(r)
+	}
+	if err := m.CheckPasswordAlgorithms(s.passwordAlgorithms()...); err != nil {
+		return r.NewErrorResponse(ErrorCodeBadRequest, "Bad Request")
+	}
+	// The nonce remains valid, so echo it back rather than issuing a new one a client would take as a new challenge
+	if parseErr != nil || string(m.realm) != s.Realm {
+		b := r.NewErrorResponse(ErrorCodeUnauthenticated, "Unauthenticated")
+		s.setChallenge(b, m.nonce)
+		return b
+	}
+	return nil
+}
+
+// unauthenticated returns a 401 error response carrying the REALM, NONCE and PASSWORD-ALGORITHMS a client requires to
+// authenticate.
+func (s *Server) unauthenticated(r *Request) *Builder {
+	nonces, err := s.nonces()
+	if err != nil {
+		s.logf("stun: nonce secret generation failed: %v", err)
+		return r.NewErrorResponse(ErrorCodeServerErrorRetry, "Server Error")
+	}
+	b := r.NewErrorResponse(ErrorCodeUnauthenticated, "Unauthenticated")
+	s.setChallenge(b, nonces.Nonce(nonceFeatures, r.RemoteAddr))
+	return b
+}
+
+func (s *Server) setChallenge(b *Builder, nonce []byte) {
+	b.SetRealm(s.Realm)
+	b.SetNonce(nonce)
+	b.SetPasswordAlgorithms(s.passwordAlgorithms()...)
+}
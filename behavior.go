@@ -0,0 +1,96 @@
+package stun
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+)
+
+// paddingSize is the size of the PADDING attribute added to responses to requests with PADDING, the common Ethernet
+// MTU, so responses are fragmented.
+// See https://tools.ietf.org/html/rfc5780#section-6.1
+const paddingSize = 1500
+
+// behaviorTransport is one of the four sockets of a NAT behavior discovery server. The sockets are indexed by two
+// bits, bit 1 set for the alternate IP address and bit 0 for the alternate port, so CHANGE-REQUEST selects the socket
+// to respond from by flipping the corresponding bits of the index the request was received on.
+type behaviorTransport struct {
+	Transport
+	index int
+	// transports and addrs of all the sockets, indexed as above
+	transports *[4]*behaviorTransport
+	addrs      *[4]*net.UDPAddr
+}
+
+// serve answers the request with h, adding the RESPONSE-ORIGIN and OTHER-ADDRESS attributes and PADDING if
+// requested to success responses. Success responses are to be sent on out, the socket selected by CHANGE-REQUEST,
+// to the port selected by RESPONSE-PORT. out is nil for other responses, sent as usual.
+// See https://tools.ietf.org/html/rfc5780#section-6.1
+func (t *behaviorTransport) serve(r *Request, h Handler) (b *Builder, out Transport, to net.Addr) {
+	responsePort, hasResponsePort := r.Message.ResponsePort()
+	if r.Message.Padding() && hasResponsePort {
+		return r.NewErrorResponse(ErrorCodeBadRequest, "Bad Request"), nil, nil
+	}
+	b = h.ServeSTUN(r)
+	if b == nil || len(b.msg) < headerSize || Type(binary.BigEndian.Uint16(b.msg[:2])).Class() != ClassSuccess {
+		return b, nil, nil
+	}
+	i := t.index
+	if changeIP, changePort, ok := r.Message.ChangeRequest(); ok {
+		if changeIP {
+			i ^= 2
+		}
+		if changePort {
+			i ^= 1
+		}
+	}
+	to = r.RemoteAddr
+	if hasResponsePort {
+		if addr, ok := r.RemoteAddr.(*net.UDPAddr); ok {
+			to = &net.UDPAddr{IP: addr.IP, Port: int(responsePort), Zone: addr.Zone}
+		}
+	}
+	b.SetResponseOrigin(t.addrs[i])
+	b.SetOtherAddress(t.addrs[t.index^3])
+	if r.Message.Padding() {
+		b.SetPadding(paddingSize)
+	}
+	return b, t.transports[i].Transport, to
+}
+
+// ServeBehaviorDiscovery answers requests as a NAT behavior discovery server, on four UDP sockets bound to every
+// combination of two IP addresses and two ports. conns[0] must be bound to the primary IP address and port, conns[1]
+// to the primary IP address and alternate port, conns[2] to the alternate IP address and primary port, and conns[3]
+// to the alternate IP address and port. Responses include RESPONSE-ORIGIN and OTHER-ADDRESS, and CHANGE-REQUEST,
+// RESPONSE-PORT and PADDING are honoured. Returns as Serve, the conns are not closed.
+// See https://tools.ietf.org/html/rfc5780
+func (s *Server) ServeBehaviorDiscovery(ctx context.Context, conns [4]net.PacketConn) error {
+	var transports [4]*behaviorTransport
+	var addrs [4]*net.UDPAddr
+
+	for i, conn := range conns {
+		addr, ok := conn.LocalAddr().(*net.UDPAddr)
+		if !ok || addr.IP.IsUnspecified() {
+			return ErrBehaviorDiscoveryAddrs
+		}
+		addrs[i] = addr
+		transports[i] = &behaviorTransport{Transport: NewPacketTransport(conn), index: i, transports: &transports, addrs: &addrs}
+	}
+	if !addrs[0].IP.Equal(addrs[1].IP) || !addrs[2].IP.Equal(addrs[3].IP) || addrs[0].IP.Equal(addrs[2].IP) ||
+		addrs[0].Port != addrs[2].Port || addrs[1].Port != addrs[3].Port || addrs[0].Port == addrs[1].Port {
+		return ErrBehaviorDiscoveryAddrs
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errCh := make(chan error, len(transports))
+	for _, t := range transports {
+		go func(t *behaviorTransport) { errCh <- s.ServeTransport(ctx, t) }(t)
+	}
+	err := <-errCh
+	cancel()
+	for i := 1; i < len(transports); i++ {
+		<-errCh
+	}
+	return err
+}
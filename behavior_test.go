@@ -0,0 +1,248 @@
+package stun
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// listenBehaviorTest listens on 127.0.0.1 and 127.0.0.2, each on the same two ports, skipping the test if not
+// possible.
+func listenBehaviorTest(t *testing.T) [4]net.PacketConn {
+	t.Helper()
+	var conns [4]net.PacketConn
+	for attempt := 0; attempt < 10; attempt++ {
+		var err error
+		if conns[0], err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			t.Skipf("listen on 127.0.0.1 failed: %v", err)
+		}
+		if conns[1], err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			conns[0].Close()
+			t.Skipf("listen on 127.0.0.1 failed: %v", err)
+		}
+		primary, alternate := conns[0].LocalAddr().(*net.UDPAddr).Port, conns[1].LocalAddr().(*net.UDPAddr).Port
+		if conns[2], err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: primary}); err == nil {
+			if conns[3], err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: alternate}); err == nil {
+				return conns
+			}
+			conns[2].Close()
+		}
+		conns[0].Close()
+		conns[1].Close()
+	}
+	t.Skip("listen on 127.0.0.2 failed")
+	return conns
+}
+
+// serveBehaviorTest runs s as a NAT behavior discovery server, returning the addresses of its sockets and a function
+// to shut it down.
+func serveBehaviorTest(t *testing.T, s *Server) ([4]*net.UDPAddr, func()) {
+	t.Helper()
+	var addrs [4]*net.UDPAddr
+	conns := listenBehaviorTest(t)
+	for i, conn := range conns {
+		addrs[i] = conn.LocalAddr().(*net.UDPAddr)
+	}
+	_, shutdown := serveTest(t, s, addrs[0], func(ctx context.Context) error {
+		return s.ServeBehaviorDiscovery(ctx, conns)
+	})
+	return addrs, func() {
+		shutdown()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+}
+
+// behaviorRoundTrip sends a Binding request built by build to addr from conn, and returns the response read from
+// recv and the address it was sent from.
+func behaviorRoundTrip(t *testing.T, conn, recv net.PacketConn, addr net.Addr, build func(b *Builder)) (*Message, net.Addr) {
+	t.Helper()
+	b := New(TypeBindingRequest, txID)
+	if build != nil {
+		build(b)
+	}
+	raw, err := b.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if _, err := conn.WriteTo(raw, addr); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	recv.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, maxMessageSize)
+	n, from, err := recv.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	var p Parser
+	var m Message
+	if err := p.Parse(&m, buf[:n]); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	return &m, from
+}
+
+func TestServeBehaviorDiscovery(t *testing.T) {
+	var s Server
+	addrs, shutdown := serveBehaviorTest(t, &s)
+	defer shutdown()
+
+	conn := listenTest(t)
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr)
+
+	tests := []struct {
+		name                 string
+		changeIP, changePort bool
+		from                 int
+	}{
+		{name: "no change", from: 0},
+		{name: "change port", changePort: true, from: 1},
+		{name: "change IP", changeIP: true, from: 2},
+		{name: "change IP and port", changeIP: true, changePort: true, from: 3},
+	}
+	for _, tt := range tests {
+		m, from := behaviorRoundTrip(t, conn, conn, addrs[0], func(b *Builder) {
+			if tt.changeIP || tt.changePort {
+				b.SetChangeRequest(tt.changeIP, tt.changePort)
+			}
+		})
+		if m.Type() != TypeBindingSuccess {
+			t.Fatalf("%s: expected success response, got %v", tt.name, m.Type())
+		}
+		if !sameAddr(from, addrs[tt.from]) {
+			t.Fatalf("%s: expected response from %s, got %s", tt.name, addrs[tt.from], from)
+		}
+		if ip, port, ok := m.ResponseOrigin(); !ok || !ip.Equal(addrs[tt.from].IP) || int(port) != addrs[tt.from].Port {
+			t.Fatalf("%s: expected response origin %s, got %s:%d", tt.name, addrs[tt.from], ip, port)
+		}
+		if ip, port, ok := m.OtherAddress(); !ok || !ip.Equal(addrs[3].IP) || int(port) != addrs[3].Port {
+			t.Fatalf("%s: expected other address %s, got %s:%d", tt.name, addrs[3], ip, port)
+		}
+		if ip, port, ok := m.XorMappedAddress(); !ok || !ip.Equal(local.IP) || int(port) != local.Port {
+			t.Fatalf("%s: expected xor mapped address %s, got %s:%d", tt.name, local, ip, port)
+		}
+	}
+
+	// Received on the alternate address and port, the other address is the primary
+	m, _ := behaviorRoundTrip(t, conn, conn, addrs[3], nil)
+	if ip, port, ok := m.OtherAddress(); !ok || !ip.Equal(addrs[0].IP) || int(port) != addrs[0].Port {
+		t.Fatalf("expected other address %s, got %s:%d", addrs[0], ip, port)
+	}
+}
+
+func TestServeBehaviorDiscoveryResponsePort(t *testing.T) {
+	var s Server
+	addrs, shutdown := serveBehaviorTest(t, &s)
+	defer shutdown()
+
+	conn := listenTest(t)
+	defer conn.Close()
+	recv := listenTest(t)
+	defer recv.Close()
+
+	m, from := behaviorRoundTrip(t, conn, recv, addrs[0], func(b *Builder) {
+		b.SetResponsePort(uint16(recv.LocalAddr().(*net.UDPAddr).Port))
+	})
+	if !sameAddr(from, addrs[0]) {
+		t.Fatalf("expected response from %s, got %s", addrs[0], from)
+	}
+	local := conn.LocalAddr().(*net.UDPAddr)
+	if ip, port, ok := m.XorMappedAddress(); !ok || !ip.Equal(local.IP) || int(port) != local.Port {
+		t.Fatalf("expected xor mapped address %s, got %s:%d", local, ip, port)
+	}
+}
+
+func TestServeBehaviorDiscoveryPadding(t *testing.T) {
+	var s Server
+	addrs, shutdown := serveBehaviorTest(t, &s)
+	defer shutdown()
+
+	conn := listenTest(t)
+	defer conn.Close()
+
+	m, _ := behaviorRoundTrip(t, conn, conn, addrs[0], func(b *Builder) { b.SetPadding(8) })
+	if m.Type() != TypeBindingSuccess || !m.Padding() || len(m.raw) < paddingSize {
+		t.Fatalf("expected padded success response, got %v of %d bytes", m.Type(), len(m.raw))
+	}
+
+	m, _ = behaviorRoundTrip(t, conn, conn, addrs[0], func(b *Builder) {
+		b.SetPadding(8)
+		b.SetResponsePort(uint16(conn.LocalAddr().(*net.UDPAddr).Port))
+	})
+	if code, _, ok := m.ErrorCode(); !ok || code != ErrorCodeBadRequest {
+		t.Fatalf("expected 400 for PADDING with RESPONSE-PORT, got %v", m.Type())
+	}
+}
+
+func TestServeBehaviorDiscoveryUnsentResponse(t *testing.T) {
+	var s Server
+	s.Handle(MethodBinding, HandlerFunc(func(r *Request) *Builder {
+		b := r.NewResponse()
+		b.SetXorMappingAddress(r.RemoteAddr.(*net.UDPAddr))
+		if _, _, ok := r.Message.ChangeRequest(); ok {
+			// USERNAME is not permitted in success responses, so building fails
+			b.SetUsername("user")
+		}
+		return b
+	}))
+	addrs, shutdown := serveBehaviorTest(t, &s)
+	defer shutdown()
+
+	conn := listenTest(t)
+	defer conn.Close()
+	other := listenTest(t)
+	defer other.Close()
+
+	b := New(TypeBindingRequest, txID)
+	b.SetChangeRequest(true, true)
+	raw, err := b.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if _, err := conn.WriteTo(raw, addrs[0]); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	// The 420 response is not redirected as the unsent response to the earlier request would have been
+	m, from := behaviorRoundTrip(t, other, other, addrs[0], func(b *Builder) {
+		b.msg = appendAttribute(b.msg, 0x0040, nil)
+	})
+	if code, _, ok := m.ErrorCode(); !ok || code != ErrorCodeUnknownAttribute {
+		t.Fatalf("expected 420, got %v", m.Type())
+	}
+	if !sameAddr(from, addrs[0]) {
+		t.Fatalf("expected response from %s, got %s", addrs[0], from)
+	}
+}
+
+func TestServerChangeRequestUnsupported(t *testing.T) {
+	var s Server
+	addr, shutdown := serveUDPTest(t, &s)
+	defer shutdown()
+
+	conn := listenTest(t)
+	defer conn.Close()
+
+	m, _ := behaviorRoundTrip(t, conn, conn, addr, func(b *Builder) { b.SetChangeRequest(true, true) })
+	if code, _, ok := m.ErrorCode(); !ok || code != ErrorCodeUnknownAttribute {
+		t.Fatalf("expected 420, got %v", m.Type())
+	}
+	if u := m.UnknownAttributes(); len(u) != 1 || u[0] != AttrChangeRequest {
+		t.Fatalf("expected CHANGE-REQUEST unknown, got %v", u)
+	}
+}
+
+func TestServeBehaviorDiscoveryAddrs(t *testing.T) {
+	var conns [4]net.PacketConn
+	for i := range conns {
+		conns[i] = listenTest(t)
+		defer conns[i].Close()
+	}
+	var s Server
+	if err := s.ServeBehaviorDiscovery(context.Background(), conns); err != ErrBehaviorDiscoveryAddrs {
+		t.Fatalf("expected ErrBehaviorDiscoveryAddrs for a single IP address, got %v", err)
+	}
+}
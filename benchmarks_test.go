@@ -28,6 +28,7 @@ func BenchmarkParseMessageIntegrity(b *testing.B) {
 		b.Fatalf("build failed: %v", err)
 	}
 	var p Parser
+	p.SetPassword(testPassword)
 	var m Message
 
 	b.ReportAllocs()
@@ -45,9 +46,28 @@ func BenchmarkParseMessageIntegritySHA256(b *testing.B) {
 		b.Fatalf("build failed: %v", err)
 	}
 	var p Parser
+	p.SetPassword(testPassword)
 	var m Message
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
 		_ = p.Parse(&m, raw)
 	}
 }
+
+func BenchmarkAttributes(b *testing.B) {
+	bb := New(TypeBindingRequest, txID)
+	bb.SetSoftware("test")
+	bb.SetPriority(110, 1, 1)
+	bb.SetUsername("evtj:h6vY")
+	bb.AddFingerprint()
+	raw, err := bb.Build()
+	if err != nil {
+		b.Fatalf("build failed: %v", err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		it := Attributes(raw)
+		for _, _, ok := it.Next(); ok; _, _, ok = it.Next() {
+		}
+	}
+}
@@ -7,13 +7,15 @@ import (
 )
 
 const (
-	maxUsernameByteLength = 513
-	maxRealmByteLength    = 763
+	maxUsernameByteLength        = 513
+	maxRealmByteLength           = 763
+	maxNonceByteLength           = 763
+	maxReasonByteLength          = 763
+	maxSoftwareByteLength        = 763
+	maxAlternateDomainByteLength = 255
+	maxPaddingByteLength         = 0xFFFC
 )
 
-// @TODO Enforce attributes to each STUN message class they belong
-// @TODO Check for duplicate attributes appended?
-
 type Builder struct {
 	err                          error
 	msg                          []byte
@@ -21,6 +23,7 @@ type Builder struct {
 	messageIntegritySHA256Length int
 	messageIntegrity             bool
 	fingerprint                  bool
+	passwordAlgorithms           bool
 }
 
 func New(t Type, txID TxID) *Builder {
@@ -79,6 +82,17 @@ func (b *Builder) SetUserHash(username, realm string) {
 	b.msg = appendUserHash(b.msg, username, realm)
 }
 
+// SetUserWithSecurityFeatures appends a USERHASH attribute if the server advertised FeatureUserAnonyminity in
+// it's nonce, otherwise a USERNAME attribute.
+// See https://tools.ietf.org/html/rfc8489#section-9.2.5
+func (b *Builder) SetUserWithSecurityFeatures(features Features, username, realm string) {
+	if features&FeatureUserAnonyminity != 0 {
+		b.SetUserHash(username, realm)
+		return
+	}
+	b.SetUsername(username)
+}
+
 // See https://tools.ietf.org/html/rfc8489#section-14.5
 func (b *Builder) AddMessageIntegrity() {
 	b.messageIntegrity = true
@@ -111,8 +125,6 @@ func (b *Builder) AddFingerprint() {
 
 // See https://tools.ietf.org/html/rfc8489#section-14.8
 func (b *Builder) SetErrorCode(errorCode ErrorCode, reason string) {
-	const maxReasonByteLength = 763
-
 	if b.err != nil {
 		return
 	}
@@ -141,8 +153,6 @@ func (b *Builder) SetRealm(realm string) {
 
 // See https://tools.ietf.org/html/rfc8489#section-14.10
 func (b *Builder) SetNonce(nonce []byte) {
-	const maxNonceByteLength = 763
-
 	if b.err != nil {
 		return
 	}
@@ -156,8 +166,6 @@ func (b *Builder) SetNonce(nonce []byte) {
 // See https://tools.ietf.org/html/rfc8489#section-14.10
 // & https://tools.ietf.org/html/rfc8489#section-9.2.1
 func (b *Builder) SetNonceWithSecurityFeatures(features Features, nonce []byte) {
-	const maxNonceByteLength = 763
-
 	if b.err != nil {
 		return
 	}
@@ -168,15 +176,33 @@ func (b *Builder) SetNonceWithSecurityFeatures(features Features, nonce []byte)
 	b.msg = appendNonceWithSecurityFeatures(b.msg, features, nonce)
 }
 
+// SetPasswordAlgorithms appends the PASSWORD-ALGORITHMS attribute. Servers advertise the algorithms they support in
+// 401 responses, and clients echo the list received back. When called before SetKeyLongTerm, a PASSWORD-ALGORITHM
+// attribute will be added even for PasswordAlgorithmMD5.
 // See https://tools.ietf.org/html/rfc8489#section-14.11
-func (b *Builder) SetPasswordAlgorithms() {
-	// @TODO
+func (b *Builder) SetPasswordAlgorithms(passwordAlgorithms ...PasswordAlgorithm) {
+	if b.err != nil {
+		return
+	}
+	if len(passwordAlgorithms) == 0 {
+		b.err = ErrMissingPasswordAlgorithms
+		return
+	}
+	for _, a := range passwordAlgorithms {
+		if a != PasswordAlgorithmMD5 && a != PasswordAlgorithmSHA256 {
+			b.err = ErrUnknownPasswordAlgorithm
+			return
+		}
+	}
+	b.msg = appendPasswordAlgorithms(b.msg, passwordAlgorithms)
+	b.passwordAlgorithms = true
 }
 
 // SetUnknownAttributes
 // Adds an Error Code attribute of ErrorCodeUnknownAttribute and with the given reason
+// The attributes are typically those returned by Message.UnknownComprehensionRequired
 // See https://tools.ietf.org/html/rfc8489#section-14.13
-func (b *Builder) SetUnknownAttributes(reason string, attributes ...uint16) {
+func (b *Builder) SetUnknownAttributes(reason string, attributes ...Attr) {
 	if b.err != nil {
 		return
 	}
@@ -187,8 +213,6 @@ func (b *Builder) SetUnknownAttributes(reason string, attributes ...uint16) {
 // SetSoftware appends Software attribute to the STUN message
 // See https://tools.ietf.org/html/rfc8489#section-14.14
 func (b *Builder) SetSoftware(software string) {
-	const maxSoftwareByteLength = 763
-
 	if b.err != nil {
 		return
 	}
@@ -213,8 +237,6 @@ func (b *Builder) SetAlternateServer(ip net.IP, port uint16) {
 
 // See https://tools.ietf.org/html/rfc8489#section-14.16
 func (b *Builder) SetAlternateDomain(domain string) {
-	const maxAlternateDomainByteLength = 255
-
 	if b.err != nil {
 		return
 	}
@@ -225,6 +247,69 @@ func (b *Builder) SetAlternateDomain(domain string) {
 	b.msg = appendAlternateDomain(b.msg, domain)
 }
 
+// SetChangeRequest adds a CHANGE-REQUEST attribute, asking a NAT behavior discovery server to respond from its
+// alternate IP address and/or port.
+// See https://tools.ietf.org/html/rfc5780#section-7.2
+func (b *Builder) SetChangeRequest(changeIP, changePort bool) {
+	if b.err != nil {
+		return
+	}
+	var flags uint32
+	if changeIP {
+		flags |= changeIPFlag
+	}
+	if changePort {
+		flags |= changePortFlag
+	}
+	b.msg = appendChangeRequest(b.msg, flags)
+}
+
+// SetResponsePort adds a RESPONSE-PORT attribute, asking a NAT behavior discovery server to respond to port.
+// See https://tools.ietf.org/html/rfc5780#section-7.5
+func (b *Builder) SetResponsePort(port uint16) {
+	if b.err != nil {
+		return
+	}
+	b.msg = appendResponsePort(b.msg, port)
+}
+
+// SetPadding adds a PADDING attribute with a value of n bytes.
+// See https://tools.ietf.org/html/rfc5780#section-7.6
+func (b *Builder) SetPadding(n int) {
+	if b.err != nil {
+		return
+	}
+	if n < 0 || n > maxPaddingByteLength {
+		b.err = ErrPaddingTooLong
+		return
+	}
+	b.msg = appendPadding(b.msg, n)
+}
+
+// See https://tools.ietf.org/html/rfc5780#section-7.3
+func (b *Builder) SetResponseOrigin(addr *net.UDPAddr) {
+	if b.err != nil {
+		return
+	}
+	if len(addr.IP) != net.IPv4len && len(addr.IP) != net.IPv6len {
+		b.err = ErrInvalidIPAddress
+		return
+	}
+	b.msg = appendResponseOrigin(b.msg, addr.IP, uint16(addr.Port))
+}
+
+// See https://tools.ietf.org/html/rfc5780#section-7.4
+func (b *Builder) SetOtherAddress(addr *net.UDPAddr) {
+	if b.err != nil {
+		return
+	}
+	if len(addr.IP) != net.IPv4len && len(addr.IP) != net.IPv6len {
+		b.err = ErrInvalidIPAddress
+		return
+	}
+	b.msg = appendOtherAddress(b.msg, addr.IP, uint16(addr.Port))
+}
+
 func (b *Builder) SetPriority(typePref uint8, localPref uint16, componentID uint8) {
 	if b.err != nil {
 		return
@@ -256,7 +341,8 @@ func (b *Builder) SetPassword(password string) {
 }
 
 // SetKeyLongTerm sets the long term key used in computing the MessageIntegrity and MessageIntegritySHA256 attributes
-// Will automatically add PASSWORD-ALGORITHM attribute if passwordAlgorithm is anything other than PasswordAlgorithmMD5
+// Will automatically add PASSWORD-ALGORITHM attribute if passwordAlgorithm is anything other than PasswordAlgorithmMD5,
+// or SetPasswordAlgorithms has been called
 func (b *Builder) SetKeyLongTerm(passwordAlgorithm PasswordAlgorithm, username, realm, password string) {
 	if b.err != nil {
 		return
@@ -267,6 +353,9 @@ func (b *Builder) SetKeyLongTerm(passwordAlgorithm PasswordAlgorithm, username,
 	}
 	switch passwordAlgorithm {
 	case PasswordAlgorithmMD5:
+		if b.passwordAlgorithms {
+			b.msg = appendPasswordAlgorithm(b.msg, PasswordAlgorithmMD5, nil)
+		}
 		b.key = appendLongTermKeyMD5String(b.key[:0], username, realm, password)
 	case PasswordAlgorithmSHA256:
 		b.msg = appendPasswordAlgorithm(b.msg, PasswordAlgorithmSHA256, nil)
@@ -281,6 +370,9 @@ func (b *Builder) Build() ([]byte, error) {
 	if b.err != nil {
 		return nil, b.err
 	}
+	if err := validateAttributes(b.msg); err != nil {
+		return nil, err
+	}
 	m := b.msg
 	if b.messageIntegrity || b.messageIntegritySHA256Length > 0 {
 		if len(b.key) == 0 {
@@ -302,3 +394,64 @@ func (b *Builder) Build() ([]byte, error) {
 	binary.BigEndian.PutUint16(m[2:4], uint16(len(m)-headerSize))
 	return m, nil
 }
+
+// classes is a set of message classes
+type classes uint8
+
+const (
+	request    = classes(1 << ClassRequest)
+	indication = classes(1 << ClassIndication)
+	success    = classes(1 << ClassSuccess)
+	failure    = classes(1 << ClassError)
+
+	allClasses = request | indication | success | failure
+)
+
+// attributeClasses returns the message classes an attribute may appear in.
+func attributeClasses(a Attr) classes {
+	switch a {
+	case AttrMappedAddress, AttrXorMappedAddress, AttrResponseOrigin, AttrOtherAddress:
+		return success
+	case AttrErrorCode, AttrUnknownAttributes, AttrAlternateServer, AttrAlternateDomain:
+		return failure
+	case AttrUsername, AttrUserHash, AttrPasswordAlgorithm:
+		return request | indication
+	case AttrPasswordAlgorithms:
+		return request | failure
+	case AttrPriority, AttrUseCandidate, AttrICEControlled, AttrICEControlling, AttrChangeRequest, AttrResponsePort:
+		return request
+	}
+	return allClasses
+}
+
+// validateAttributes checks the attributes of the message being built are permitted in its class, that none are
+// duplicated, and that error responses have an ERROR-CODE.
+func validateAttributes(m []byte) error {
+	var buf [32]Attr
+
+	class := Type(binary.BigEndian.Uint16(m[:2])).Class()
+	seen := buf[:0]
+	errorCode := false
+	for attrs := m[headerSize:]; len(attrs) >= 4; {
+		a, n := attributeType(attrs), (attributeSize(attrs)+7)&^3
+		// Attributes appended without validation may claim more bytes than follow
+		if len(attrs) < n {
+			return ErrUnexpectedEOF
+		}
+		attrs = attrs[n:]
+		if attributeClasses(a)&(1<<class) == 0 {
+			return &AttributeError{Attr: a, Class: class, Err: ErrAttributeNotAllowed}
+		}
+		for _, s := range seen {
+			if s == a {
+				return &AttributeError{Attr: a, Class: class, Err: ErrDuplicateAttribute}
+			}
+		}
+		seen = append(seen, a)
+		errorCode = errorCode || a == AttrErrorCode
+	}
+	if class == ClassError && !errorCode {
+		return ErrMissingErrorCode
+	}
+	return nil
+}
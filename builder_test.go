@@ -1,6 +1,7 @@
 package stun
 
 import (
+	"errors"
 	"net"
 	"testing"
 )
@@ -38,7 +39,7 @@ func TestBuilderIPAddressLengthValidation(t *testing.T) {
 
 	for _, tt := range tests {
 		{
-			b := New(TypeBindingRequest, testTxID)
+			b := New(TypeBindingSuccess, testTxID)
 			b.SetXorMappingAddress(&net.UDPAddr{IP: tt.ip, Port: 1234})
 			raw, err := b.Build()
 			if err != tt.err {
@@ -51,7 +52,7 @@ func TestBuilderIPAddressLengthValidation(t *testing.T) {
 			}
 		}
 		{
-			b := New(TypeBindingRequest, testTxID)
+			b := New(TypeBindingSuccess, testTxID)
 			b.SetMappingAddress(&net.UDPAddr{IP: tt.ip, Port: 1234})
 			raw, err := b.Build()
 			if err != tt.err {
@@ -64,7 +65,8 @@ func TestBuilderIPAddressLengthValidation(t *testing.T) {
 			}
 		}
 		{
-			b := New(TypeBindingRequest, testTxID)
+			b := New(TypeBindingError, testTxID)
+			b.SetErrorCode(ErrorCodeTryAlternate, "Try Alternate")
 			b.SetAlternateServer(tt.ip, 1234)
 			raw, err := b.Build()
 			if err != tt.err {
@@ -78,3 +80,122 @@ func TestBuilderIPAddressLengthValidation(t *testing.T) {
 		}
 	}
 }
+
+func TestBuilderSetUserWithSecurityFeatures(t *testing.T) {
+	var p Parser
+	var m Message
+
+	for _, f := range []Features{0, FeatureUserAnonyminity} {
+		b := New(TypeBindingRequest, testTxID)
+		b.SetUserWithSecurityFeatures(f, "user", "example.org")
+		raw, err := b.Build()
+		if err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		if err := p.Parse(&m, raw); err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		if anonymous := f&FeatureUserAnonyminity != 0; anonymous != (m.UserHash() != nil) || anonymous == (m.Username() != nil) {
+			t.Fatalf("features %x: unexpected username %q, userhash %x", f, m.Username(), m.UserHash())
+		}
+	}
+}
+
+func TestBuilderAttributeValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func() *Builder
+		attr  Attr
+		err   error
+	}{
+		{
+			name: "error code on request",
+			build: func() *Builder {
+				b := New(TypeBindingRequest, testTxID)
+				b.SetErrorCode(ErrorCodeBadRequest, "Bad Request")
+				return b
+			},
+			attr: AttrErrorCode,
+			err:  ErrAttributeNotAllowed,
+		},
+		{
+			name: "xor mapped address on request",
+			build: func() *Builder {
+				b := New(TypeBindingRequest, testTxID)
+				b.SetXorMappingAddress(&net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 1234})
+				return b
+			},
+			attr: AttrXorMappedAddress,
+			err:  ErrAttributeNotAllowed,
+		},
+		{
+			name: "username on success",
+			build: func() *Builder {
+				b := New(TypeBindingSuccess, testTxID)
+				b.SetUsername("user")
+				return b
+			},
+			attr: AttrUsername,
+			err:  ErrAttributeNotAllowed,
+		},
+		{
+			name: "duplicate username",
+			build: func() *Builder {
+				b := New(TypeBindingRequest, testTxID)
+				b.SetUsername("user")
+				b.SetSoftware("test")
+				b.SetUsername("user")
+				return b
+			},
+			attr: AttrUsername,
+			err:  ErrDuplicateAttribute,
+		},
+		{
+			name: "duplicate error code",
+			build: func() *Builder {
+				b := New(TypeBindingError, testTxID)
+				b.SetErrorCode(ErrorCodeBadRequest, "Bad Request")
+				b.SetUnknownAttributes("Unknown Attribute", AttrUseCandidate)
+				return b
+			},
+			attr: AttrErrorCode,
+			err:  ErrDuplicateAttribute,
+		},
+		{
+			name: "duplicate after many attributes",
+			build: func() *Builder {
+				b := New(TypeBindingRequest, testTxID)
+				for a := Attr(0xC000); a < 0xC028; a++ {
+					b.msg = appendAttribute(b.msg, a, nil)
+				}
+				b.msg = appendAttribute(b.msg, 0xC024, nil)
+				return b
+			},
+			attr: 0xC024,
+			err:  ErrDuplicateAttribute,
+		},
+	}
+	for _, tt := range tests {
+		_, err := tt.build().Build()
+		var e *AttributeError
+		if !errors.As(err, &e) {
+			t.Fatalf("%s: expected *AttributeError, got %v", tt.name, err)
+		}
+		if e.Attr != tt.attr || !errors.Is(err, tt.err) {
+			t.Fatalf("%s: expected %v for attribute %#04x, got %v", tt.name, tt.err, tt.attr, err)
+		}
+	}
+
+	if _, err := New(TypeBindingError, testTxID).Build(); err != ErrMissingErrorCode {
+		t.Fatalf("expected ErrMissingErrorCode, got %v", err)
+	}
+}
+
+func TestBuilderTruncatedAttribute(t *testing.T) {
+	b := New(TypeBindingRequest, testTxID)
+	// SOFTWARE claiming 100 bytes, with only 4 following
+	b.msg = append(b.msg, 0x80, 0x22, 0, 100, 't', 'e', 's', 't')
+	if _, err := b.Build(); err != ErrUnexpectedEOF {
+		t.Fatalf("expected ErrUnexpectedEOF, got %v", err)
+	}
+}
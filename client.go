@@ -0,0 +1,611 @@
+package stun
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/dtls/v2"
+)
+
+// Retransmission defaults.
+// See https://tools.ietf.org/html/rfc8489#section-6.2.1
+const (
+	DefaultRTO = 500 * time.Millisecond
+	DefaultRc  = 7
+	DefaultRm  = 16
+	// DefaultTi is the transaction timeout over reliable transports, which are not retransmitted.
+	// See https://tools.ietf.org/html/rfc8489#section-6.2.2
+	DefaultTi = 39500 * time.Millisecond
+)
+
+// DefaultMaxRedirects is the default for Client.MaxRedirects.
+const DefaultMaxRedirects = 3
+
+const (
+	// minRTO and maxRTO bound the RTO derived from RTT estimates.
+	minRTO = 100 * time.Millisecond
+	maxRTO = 60 * time.Second
+	// rttCacheLifetime is how long an RTT estimate for a server is retained since its last update.
+	// See https://tools.ietf.org/html/rfc8489#section-6.2.1
+	rttCacheLifetime = 10 * time.Minute
+)
+
+// Response is a response received by a Client. The Message is parsed from a buffer owned by the Response, so remains
+// valid for the lifetime of the Response.
+type Response struct {
+	Message
+	// From is the transport address the response was received from.
+	From net.Addr
+	// LocalAddr is the local transport address the response was received on.
+	LocalAddr net.Addr
+	// RTT is the time between the last retransmission of the request and receipt of the response.
+	RTT time.Duration
+	// Redirects lists the 300 Try Alternate responses followed before this response was received, in order.
+	Redirects []Redirect
+}
+
+// Redirect records a 300 Try Alternate response followed by Client.
+// See https://tools.ietf.org/html/rfc8489#section-10
+type Redirect struct {
+	// From is the server that answered with the redirect.
+	From net.Addr
+	// To is the ALTERNATE-SERVER address the request was sent to instead.
+	To net.Addr
+	// Domain is the ALTERNATE-DOMAIN, if any, the name the alternate server's TLS certificate is validated against.
+	Domain string
+}
+
+// alternateServerAddr returns the ALTERNATE-SERVER of a 300 Try Alternate response, using the same transport as addr,
+// or false if the response is not a redirect that should be followed. Redirects in response to authenticated requests
+// must themselves be authenticated.
+// See https://tools.ietf.org/html/rfc8489#section-10
+func (r *Response) alternateServerAddr(authenticated bool, addr net.Addr) (net.Addr, bool) {
+	if r.Type().Class() != ClassError || r.has&hasErrorCode == 0 || r.errorCode != ErrorCodeTryAlternate {
+		return nil, false
+	}
+	if authenticated && !r.integrity() {
+		return nil, false
+	}
+	ip, port, ok := r.AlternateServer()
+	if !ok {
+		return nil, false
+	}
+	switch addr.(type) {
+	case *net.TCPAddr:
+		return &net.TCPAddr{IP: append(net.IP(nil), ip...), Port: int(port)}, true
+	case *TLSAddr, *DTLSAddr:
+		// The alternate server's certificate is validated against ALTERNATE-DOMAIN, so it is required
+		if len(r.alternateDomain) == 0 {
+			return nil, false
+		}
+		ip := append(net.IP(nil), ip...)
+		if _, ok := addr.(*DTLSAddr); ok {
+			return &DTLSAddr{UDPAddr: net.UDPAddr{IP: ip, Port: int(port)}, ServerName: string(r.alternateDomain)}, true
+		}
+		return &TLSAddr{TCPAddr: net.TCPAddr{IP: ip, Port: int(port)}, ServerName: string(r.alternateDomain)}, true
+	}
+	return &net.UDPAddr{IP: append(net.IP(nil), ip...), Port: int(port)}, true
+}
+
+// Client runs STUN transactions over UDP, TCP, TLS and DTLS.
+// Over UDP requests are sent on the Client's Transport, retransmitted with exponential backoff until a response
+// arrives, Rc requests have been sent, or the context is done. The RTT to each server is estimated from transactions that
+// required no retransmission, and used to adapt the RTO for later transactions.
+// Over TCP a connection is dialed to each server on first use, and reused for later transactions. Requests are sent
+// once, and fail if no response arrives within Ti. Likewise over TLS, and DTLS, although as DTLS is unreliable requests
+// are retransmitted as over UDP. In general requests are retransmitted only over a Transport that is not Reliable.
+// Transactions may be run concurrently from many goroutines. Responses are routed by transaction ID to the waiting
+// transaction, discarding any with an unknown transaction ID or from an address other than the one the request was
+// sent to.
+// A Client reads from its Transport in a background goroutine, so Close must be called once it is no longer needed.
+// See https://tools.ietf.org/html/rfc8489#section-6.2
+type Client struct {
+	// RTO is the initial retransmission timeout for servers without an RTT estimate. Defaults to DefaultRTO.
+	RTO time.Duration
+	// Rc is the maximum number of times a request is sent. Defaults to DefaultRc.
+	Rc int
+	// Rm is the multiple of RTO to wait for a response after the last request is sent. Defaults to DefaultRm.
+	Rm int
+	// Ti is the transaction timeout over TCP. Defaults to DefaultTi.
+	Ti time.Duration
+	// ProbeTimeout, if not zero, bounds the wait for responses to the requests of DiscoverNATBehavior that go
+	// unanswered depending on the NAT's behavior, rather than their retransmissions timing out.
+	ProbeTimeout time.Duration
+	// Dialer dials TCP connections. If nil the zero net.Dialer is used.
+	Dialer *net.Dialer
+	// TLSConfig is the base configuration for TLS connections. The ServerName is taken from the *TLSAddr, and
+	// ALPNNATDiscovery is offered if NextProtos is empty.
+	TLSConfig *tls.Config
+	// DTLSConfig is the base configuration for DTLS connections. The ServerName is taken from the *DTLSAddr, and
+	// ALPNNATDiscovery is offered if SupportedProtocols is empty. Sessions are resumed if it has a SessionStore.
+	DTLSConfig *dtls.Config
+	// Software, if not empty, is added to every request.
+	Software string
+	// Resolver is used to discover servers by DoURI. If nil net.DefaultResolver is used.
+	Resolver Resolver
+	// MaxRedirects is the maximum number of 300 Try Alternate responses followed by a single call to Do. Defaults to
+	// DefaultMaxRedirects.
+	MaxRedirects int
+	// Username and Password are the long term credentials used to authenticate requests to servers that answer with
+	// a 401 response carrying a REALM and NONCE. The realm and nonce are cached per server and used for later requests,
+	// with 438 responses retried using the fresh nonce.
+	// See https://tools.ietf.org/html/rfc8489#section-9.2
+	Username string
+	Password string
+
+	// conn is the Transport requests are sent on to addresses not dialed, nil if none
+	conn Transport
+
+	mu           sync.Mutex
+	transactions map[TxID]*transaction
+	streams      map[string]*streamConn
+	auth         map[string]*longTermAuth
+	closed       bool
+	// done is closed once readLoop exits, with err the reason
+	done chan struct{}
+	err  error
+
+	rttMu sync.Mutex
+	rtt   map[string]*rttEstimate
+}
+
+// transaction is an outstanding request awaiting a response.
+type transaction struct {
+	addr net.Addr
+	// stream is the connection the request was sent on, or nil if sent on the Client's Transport
+	stream    *streamConn
+	flags     txFlags
+	responses chan packet
+}
+
+// accept parses raw into r, reporting whether it is a response the transaction should return. If p has a key success
+// responses must carry message integrity.
+func (t *transaction) accept(r *Response, p *Parser, raw []byte) bool {
+	if err := p.Parse(&r.Message, raw); err != nil {
+		return false
+	}
+	switch r.Type().Class() {
+	case ClassSuccess:
+		return len(p.key) == 0 || r.integrity()
+	case ClassError:
+		return true
+	case ClassRequest:
+		return t.flags&txEcho != 0
+	}
+	return false
+}
+
+// txFlags modify how responses are matched to a transaction.
+type txFlags uint8
+
+const (
+	// txAnyAddr accepts responses from any address, as requested by CHANGE-REQUEST.
+	txAnyAddr txFlags = 1 << iota
+	// txEcho accepts the request itself, returned by a NAT that hairpins.
+	txEcho
+)
+
+// packet is a copy of a message received from addr on local, in buf taken from packetPool.
+type packet struct {
+	raw   []byte
+	from  net.Addr
+	local net.Addr
+	buf   *[maxMessageSize]byte
+}
+
+// packetPool holds the buffers messages are copied into when routed to a transaction. A buffer is owned by the
+// Response parsed from it, or returned to the pool if the message is discarded.
+var packetPool = sync.Pool{New: func() interface{} { return new([maxMessageSize]byte) }}
+
+// release returns the packet's buffer to packetPool, once nothing references it.
+func (p packet) release() { packetPool.Put(p.buf) }
+
+// NewClient returns a Client sending UDP requests on conn. The Client reads from conn until Close is called, which
+// must be called before conn can be read by others. conn is not closed, so remains usable once the Client is closed.
+// conn may be nil if only TCP, TLS or DTLS are used.
+func NewClient(conn net.PacketConn) *Client {
+	if conn == nil {
+		return NewClientTransport(nil)
+	}
+	return NewClientTransport(NewPacketTransport(conn))
+}
+
+// NewClientTransport returns a Client sending requests to addresses other than *net.TCPAddr, *TLSAddr and *DTLSAddr
+// on t, retransmitting them only if t is unreliable. The Client reads from t until Close is called. t may be nil.
+func NewClientTransport(t Transport) *Client {
+	c := &Client{
+		conn:         t,
+		transactions: make(map[TxID]*transaction),
+		streams:      make(map[string]*streamConn),
+		done:         make(chan struct{}),
+	}
+	if t != nil {
+		go c.readLoop()
+	}
+	return c
+}
+
+// Close stops the Client reading from its Transport and closes its TCP, TLS and DTLS connections, failing
+// outstanding transactions with ErrClientClosed. The Transport is not closed, and its read deadline is cleared once
+// the Client has stopped reading from it.
+func (c *Client) Close() error {
+	var err error
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	streams := c.streams
+	c.streams = nil
+	c.mu.Unlock()
+	for _, sc := range streams {
+		sc.conn.Close()
+		<-sc.done
+	}
+	if c.conn == nil {
+		c.err = ErrClientClosed
+		close(c.done)
+		return nil
+	}
+	err = c.conn.SetReadDeadline(aLongTimeAgo)
+	<-c.done
+	if e := c.conn.SetReadDeadline(time.Time{}); err == nil {
+		err = e
+	}
+	return err
+}
+
+// route passes a copy of the message received from addr on local, over stream or the Client's Transport if nil, to
+// the transaction waiting for it, if any.
+func (c *Client) route(in []byte, from, local net.Addr, stream *streamConn) {
+	var txID TxID
+
+	if len(in) > maxMessageSize || validateHeader(in) != nil {
+		return
+	}
+	copy(txID[:], in[8:headerSize])
+	c.mu.Lock()
+	t, ok := c.transactions[txID]
+	c.mu.Unlock()
+	if !ok || t.stream != stream || (t.flags&txAnyAddr == 0 && !sameAddr(from, t.addr)) {
+		return
+	}
+	buf := packetPool.Get().(*[maxMessageSize]byte)
+	pkt := packet{raw: buf[:copy(buf[:], in)], from: from, local: local, buf: buf}
+	select {
+	case t.responses <- pkt:
+	default:
+		// Duplicate response to a retransmitted request
+		pkt.release()
+	}
+}
+
+func (c *Client) readLoop() {
+	defer close(c.done)
+	for {
+		in, from, local, err := c.conn.ReadMessage()
+		if err != nil {
+			c.mu.Lock()
+			closed := c.closed
+			c.mu.Unlock()
+			if closed {
+				c.err = ErrClientClosed
+				return
+			}
+			if transientError(err) {
+				continue
+			}
+			c.err = err
+			return
+		}
+		c.route(in, from, local, nil)
+	}
+}
+
+// Binding performs a Binding request to addr.
+// See https://tools.ietf.org/html/rfc8489#section-3
+func (c *Client) Binding(ctx context.Context, addr net.Addr) (*Response, error) {
+	return c.Do(ctx, addr, MethodBinding, nil)
+}
+
+// BindingURI performs a Binding request to the server identified by the URI.
+func (c *Client) BindingURI(ctx context.Context, u *URI) (*Response, error) {
+	return c.DoURI(ctx, u, MethodBinding, nil)
+}
+
+// DoURI is Do with the server identified by a URI, discovered using LookupURI. Each endpoint is tried in turn until
+// one responds. Plain UDP endpoints are skipped if the Client has no Transport.
+func (c *Client) DoURI(ctx context.Context, u *URI, method Method, build func(b *Builder)) (*Response, error) {
+	endpoints, err := LookupURI(ctx, c.Resolver, u)
+	if err != nil {
+		return nil, err
+	}
+	err = ErrUnsupportedTransport
+	for _, e := range endpoints {
+		if e.Transport == TransportUDP && !e.Secure && c.conn == nil {
+			continue
+		}
+		var r *Response
+		if r, err = c.Do(ctx, e.Addr(), method, build); err != ErrTimeout && !isDialError(err) {
+			return r, err
+		}
+	}
+	return nil, err
+}
+
+// Do sends a request of method to addr, with attributes added by build if not nil, and returns the response. Error
+// responses are returned along with their *ErrorResponse error. ErrTimeout is returned if no response arrives.
+// If credentials are configured, challenges from the server are answered by retrying the request, and 300 Try
+// Alternate responses are followed to the ALTERNATE-SERVER, so build may be called more than once.
+func (c *Client) Do(ctx context.Context, addr net.Addr, method Method, build func(b *Builder)) (*Response, error) {
+	var redirects []Redirect
+
+	for {
+		r, authenticated, err := c.authenticatedDo(ctx, addr, method, build, 0)
+		if err != nil {
+			return nil, err
+		}
+		r.Redirects = redirects
+		to, ok := r.alternateServerAddr(authenticated, addr)
+		if !ok {
+			return r, r.Err()
+		}
+		if len(redirects) >= c.maxRedirects() {
+			return r, ErrTooManyRedirects
+		}
+		for _, rd := range redirects {
+			if sameAddr(rd.From, to) {
+				return r, ErrRedirectLoop
+			}
+		}
+		if sameAddr(addr, to) {
+			return r, ErrRedirectLoop
+		}
+		redirects = append(redirects, Redirect{From: addr, To: to, Domain: string(r.alternateDomain)})
+		addr = to
+	}
+}
+
+// authenticatedDo runs transactions until one is not answered with a credential challenge, reporting whether the
+// final request was authenticated.
+func (c *Client) authenticatedDo(ctx context.Context, addr net.Addr, method Method, build func(b *Builder), flags txFlags) (*Response, bool, error) {
+	for i := 0; ; i++ {
+		auth := c.longTermAuth(addr)
+		r, err := c.do(ctx, addr, method, build, auth, flags)
+		if err != nil {
+			return nil, false, err
+		}
+		if i+1 >= maxAuthAttempts || r.Type().Class() != ClassError || !c.challenged(addr, auth, &r.Message) {
+			return r, auth != nil, nil
+		}
+	}
+}
+
+// do runs a single transaction, authenticated with auth if not nil.
+func (c *Client) do(ctx context.Context, addr net.Addr, method Method, build func(b *Builder), auth *longTermAuth, flags txFlags) (*Response, error) {
+	var txID TxID
+	var p Parser
+
+	if _, err := rand.Read(txID[:]); err != nil {
+		return nil, err
+	}
+	b := New(NewType(method, ClassRequest), txID)
+	if c.Software != "" {
+		b.SetSoftware(c.Software)
+	}
+	if auth != nil {
+		if err := auth.apply(b, &p, c.Username, c.Password); err != nil {
+			return nil, err
+		}
+	}
+	if build != nil {
+		build(b)
+	}
+	raw, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return c.roundTrip(ctx, addr, txID, raw, &p, flags)
+}
+
+func (c *Client) maxRedirects() int {
+	if c.MaxRedirects > 0 {
+		return c.MaxRedirects
+	}
+	return DefaultMaxRedirects
+}
+
+func (c *Client) ti() time.Duration {
+	if c.Ti > 0 {
+		return c.Ti
+	}
+	return DefaultTi
+}
+
+func (c *Client) rc() int {
+	if c.Rc > 0 {
+		return c.Rc
+	}
+	return DefaultRc
+}
+
+func (c *Client) rm() int {
+	if c.Rm > 0 {
+		return c.Rm
+	}
+	return DefaultRm
+}
+
+// roundTrip sends raw to addr until a response with txID, that p successfully parses, is received or the transaction
+// times out. If p has a key success responses must carry message integrity. Requests to a *net.TCPAddr, *TLSAddr or
+// *DTLSAddr are sent over TCP, TLS or DTLS respectively, others on the Client's Transport. Requests are
+// retransmitted, and the RTT estimated, only over unreliable transports.
+func (c *Client) roundTrip(ctx context.Context, addr net.Addr, txID TxID, raw []byte, p *Parser, flags txFlags) (*Response, error) {
+	t := &transaction{addr: addr, flags: flags, responses: make(chan packet, 1)}
+
+	var streamDone chan struct{}
+	tr := c.conn
+	if isConnAddr(addr) {
+		sc, err := c.stream(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		t.stream, streamDone, tr = sc, sc.done, sc.t
+	} else if tr == nil {
+		return nil, ErrUnsupportedTransport
+	}
+	reliable := tr.Reliable()
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	c.transactions[txID] = t
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.transactions, txID)
+		c.mu.Unlock()
+		select {
+		case pkt := <-t.responses:
+			pkt.release()
+		default:
+		}
+	}()
+
+	timer := time.NewTimer(maxRTO)
+	defer timer.Stop()
+	rto := c.rto(addr)
+	rc := c.rc()
+	if reliable {
+		rc = 1
+	}
+	for i := 0; i < rc; i++ {
+		sent := time.Now()
+		if err := tr.WriteMessage(raw, addr); err != nil {
+			return nil, err
+		}
+		wait := rto << uint(i)
+		if reliable {
+			wait = c.ti()
+		} else if i == rc-1 {
+			wait = rto * time.Duration(c.rm())
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-c.done:
+				return nil, c.err
+			case <-streamDone:
+				return nil, t.stream.err
+			case <-timer.C:
+				break wait
+			case pkt := <-t.responses:
+				r := &Response{From: pkt.from, LocalAddr: pkt.local, RTT: time.Since(sent)}
+				if !t.accept(r, p, pkt.raw) {
+					pkt.release()
+					continue
+				}
+				// Karn's algorithm, responses to retransmitted requests are ambiguous so not sampled
+				if i == 0 && !reliable {
+					c.sample(addr, r.RTT)
+				}
+				return r, nil
+			}
+		}
+	}
+	if !reliable {
+		c.timedOut(addr)
+	}
+	return nil, ErrTimeout
+}
+
+// sameAddr reports whether a and b are the same transport address.
+func sameAddr(a, b net.Addr) bool {
+	x, ok := udpAddr(a)
+	if !ok {
+		return a.String() == b.String()
+	}
+	y, ok := udpAddr(b)
+	return ok && x.IP.Equal(y.IP) && x.Port == y.Port
+}
+
+// rttEstimate holds the smoothed round trip time and variance for a server.
+// See https://tools.ietf.org/html/rfc6298#section-2
+type rttEstimate struct {
+	srtt    time.Duration
+	rttvar  time.Duration
+	rto     time.Duration
+	updated time.Time
+}
+
+func (e *rttEstimate) sample(r time.Duration) {
+	if e.srtt == 0 {
+		e.srtt = r
+		e.rttvar = r / 2
+	} else {
+		d := e.srtt - r
+		if d < 0 {
+			d = -d
+		}
+		e.rttvar = (3*e.rttvar + d) / 4
+		e.srtt = (7*e.srtt + r) / 8
+	}
+	e.rto = e.srtt + 4*e.rttvar
+	if e.rto < minRTO {
+		e.rto = minRTO
+	} else if e.rto > maxRTO {
+		e.rto = maxRTO
+	}
+}
+
+// rto returns the RTO for addr, from its RTT estimate if recent enough.
+func (c *Client) rto(addr net.Addr) time.Duration {
+	c.rttMu.Lock()
+	defer c.rttMu.Unlock()
+	if e, ok := c.rtt[addr.String()]; ok && time.Since(e.updated) < rttCacheLifetime {
+		return e.rto
+	}
+	if c.RTO > 0 {
+		return c.RTO
+	}
+	return DefaultRTO
+}
+
+func (c *Client) sample(addr net.Addr, r time.Duration) {
+	c.rttMu.Lock()
+	defer c.rttMu.Unlock()
+	if c.rtt == nil {
+		c.rtt = make(map[string]*rttEstimate)
+	}
+	e, ok := c.rtt[addr.String()]
+	if !ok || time.Since(e.updated) >= rttCacheLifetime {
+		e = &rttEstimate{}
+		c.rtt[addr.String()] = e
+	}
+	e.sample(r)
+	e.updated = time.Now()
+}
+
+// timedOut discards the RTT estimate for addr, so the next transaction starts from the initial RTO.
+func (c *Client) timedOut(addr net.Addr) {
+	c.rttMu.Lock()
+	defer c.rttMu.Unlock()
+	delete(c.rtt, addr.String())
+}
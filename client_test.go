@@ -0,0 +1,440 @@
+package stun
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func listenTest(t *testing.T) net.PacketConn {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	return pc
+}
+
+// lossyServer answers Binding requests after dropping the first drop requests received, returning its address and
+// the number of requests received.
+func lossyServer(t *testing.T, drop int32) (net.Addr, *int32, func()) {
+	t.Helper()
+	pc := listenTest(t)
+	var n int32
+	go func() {
+		var p Parser
+		var m Message
+
+		buf := make([]byte, maxMessageSize)
+		for {
+			k, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if atomic.AddInt32(&n, 1) <= drop || p.Parse(&m, buf[:k]) != nil {
+				continue
+			}
+			b := New(TypeBindingSuccess, m.TxID())
+			b.SetXorMappingAddress(addr.(*net.UDPAddr))
+			if raw, err := b.Build(); err == nil {
+				pc.WriteTo(raw, addr)
+			}
+		}
+	}()
+	return pc.LocalAddr(), &n, func() { pc.Close() }
+}
+
+func TestClientBinding(t *testing.T) {
+	var s Server
+	addr, shutdown := serveUDPTest(t, &s)
+	defer shutdown()
+
+	pc := listenTest(t)
+	defer pc.Close()
+
+	c := NewClient(pc)
+	defer c.Close()
+	r, err := c.Binding(context.Background(), addr)
+	if err != nil {
+		t.Fatalf("binding failed: %v", err)
+	}
+	local := pc.LocalAddr().(*net.UDPAddr)
+	if ip, port, ok := r.XorMappedAddress(); !ok || !ip.Equal(local.IP) || int(port) != local.Port {
+		t.Fatalf("expected xor mapped address %v, got %v:%d", local, ip, port)
+	}
+	if !sameAddr(r.From, addr) {
+		t.Fatalf("expected response from %v, got %v", addr, r.From)
+	}
+	if rto := c.rto(addr); rto != minRTO {
+		t.Fatalf("expected loopback RTO of %v, got %v", minRTO, rto)
+	}
+}
+
+func TestClientRetransmit(t *testing.T) {
+	addr, n, stop := lossyServer(t, 2)
+	defer stop()
+
+	pc := listenTest(t)
+	defer pc.Close()
+
+	c := NewClient(pc)
+	defer c.Close()
+	c.RTO = 10 * time.Millisecond
+	if _, err := c.Binding(context.Background(), addr); err != nil {
+		t.Fatalf("binding failed: %v", err)
+	}
+	if got := atomic.LoadInt32(n); got != 3 {
+		t.Fatalf("expected 3 requests, got %d", got)
+	}
+	if _, ok := c.rtt[addr.String()]; ok {
+		t.Fatal("expected no RTT sample from retransmitted transaction")
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	addr, n, stop := lossyServer(t, 1<<30)
+	defer stop()
+
+	pc := listenTest(t)
+	defer pc.Close()
+
+	c := NewClient(pc)
+	defer c.Close()
+	c.RTO = time.Millisecond
+	start := time.Now()
+	if _, err := c.Binding(context.Background(), addr); err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	// Requests sent at 0, 1, 3, 7, 15, 31 & 63ms, then waits 16ms for a response
+	if d := time.Since(start); d < 79*time.Millisecond {
+		t.Fatalf("expected timeout after at least 79ms, got %v", d)
+	}
+	if got := atomic.LoadInt32(n); got != DefaultRc {
+		t.Fatalf("expected %d requests, got %d", DefaultRc, got)
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	addr, _, stop := lossyServer(t, 1<<30)
+	defer stop()
+
+	pc := listenTest(t)
+	defer pc.Close()
+
+	c := NewClient(pc)
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.Binding(ctx, addr); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("expected cancellation to interrupt transaction, took %v", d)
+	}
+}
+
+func TestRTTEstimate(t *testing.T) {
+	var e rttEstimate
+
+	e.sample(200 * time.Millisecond)
+	if e.srtt != 200*time.Millisecond || e.rttvar != 100*time.Millisecond || e.rto != 600*time.Millisecond {
+		t.Fatalf("unexpected estimate after first sample %+v", e)
+	}
+	e.sample(200 * time.Millisecond)
+	if e.srtt != 200*time.Millisecond || e.rttvar != 75*time.Millisecond || e.rto != 500*time.Millisecond {
+		t.Fatalf("unexpected estimate after second sample %+v", e)
+	}
+	e.sample(0)
+	if e.rto < minRTO {
+		t.Fatalf("expected RTO of at least %v, got %v", minRTO, e.rto)
+	}
+}
+
+func TestClientConcurrent(t *testing.T) {
+	var s Server
+	addr, shutdown := serveUDPTest(t, &s)
+	defer shutdown()
+
+	pc := listenTest(t)
+	defer pc.Close()
+
+	c := NewClient(pc)
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Binding(context.Background(), addr)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("binding failed: %v", err)
+		}
+	}
+	if n := len(c.transactions); n != 0 {
+		t.Fatalf("expected no outstanding transactions, got %d", n)
+	}
+}
+
+func TestClientDiscardsSpoofedResponses(t *testing.T) {
+	pc := listenTest(t)
+	defer pc.Close()
+	spoofer := listenTest(t)
+	defer spoofer.Close()
+
+	go func() {
+		var p Parser
+		var m Message
+
+		buf := make([]byte, maxMessageSize)
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil || p.Parse(&m, buf[:n]) != nil {
+			return
+		}
+		// Response from the wrong address, then with the wrong transaction ID
+		b := New(TypeBindingSuccess, m.TxID())
+		b.SetXorMappingAddress(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1})
+		if raw, err := b.Build(); err == nil {
+			spoofer.WriteTo(raw, addr)
+		}
+		b = New(TypeBindingSuccess, TxID{1})
+		b.SetXorMappingAddress(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 1})
+		if raw, err := b.Build(); err == nil {
+			pc.WriteTo(raw, addr)
+		}
+		b = New(TypeBindingSuccess, m.TxID())
+		b.SetXorMappingAddress(addr.(*net.UDPAddr))
+		if raw, err := b.Build(); err == nil {
+			pc.WriteTo(raw, addr)
+		}
+	}()
+
+	conn := listenTest(t)
+	defer conn.Close()
+
+	c := NewClient(conn)
+	defer c.Close()
+	r, err := c.Binding(context.Background(), pc.LocalAddr())
+	if err != nil {
+		t.Fatalf("binding failed: %v", err)
+	}
+	local := conn.LocalAddr().(*net.UDPAddr)
+	if ip, port, ok := r.XorMappedAddress(); !ok || !ip.Equal(local.IP) || int(port) != local.Port {
+		t.Fatalf("expected xor mapped address %v, got %v:%d", local, ip, port)
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	addr, _, stop := lossyServer(t, 1<<30)
+	defer stop()
+
+	pc := listenTest(t)
+	defer pc.Close()
+
+	c := NewClient(pc)
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c.Binding(context.Background(), addr)
+		errCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	c.Close()
+	if err := <-errCh; err != ErrClientClosed {
+		t.Fatalf("expected ErrClientClosed, got %v", err)
+	}
+	if _, err := c.Binding(context.Background(), addr); err != ErrClientClosed {
+		t.Fatalf("expected ErrClientClosed after Close, got %v", err)
+	}
+
+	// pc remains usable, without the deadline used to stop the Client
+	other := listenTest(t)
+	defer other.Close()
+	if _, err := other.WriteTo([]byte("ping"), pc.LocalAddr()); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	readCh := make(chan error, 1)
+	go func() {
+		_, _, err := pc.ReadFrom(make([]byte, 16))
+		readCh <- err
+	}()
+	select {
+	case err := <-readCh:
+		if err != nil {
+			t.Fatalf("read after Close failed: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("read after Close timed out")
+	}
+}
+
+func TestClientLongTermCredentials(t *testing.T) {
+	const (
+		username = "user"
+		realm    = "example.org"
+	)
+	s := Server{
+		Credentials: testCredentials{username: username, realm: realm, password: testPassword},
+		Realm:       realm,
+		Nonces:      NewNonceManager([]byte("secret"), time.Minute),
+	}
+	addr, shutdown := serveUDPTest(t, &s)
+	defer shutdown()
+
+	pc := listenTest(t)
+	defer pc.Close()
+
+	c := NewClient(pc)
+	defer c.Close()
+	c.Username = username
+	c.Password = testPassword
+
+	r, err := c.Binding(context.Background(), addr)
+	if err != nil {
+		t.Fatalf("binding failed: %v", err)
+	}
+	if !r.integrity() {
+		t.Fatal("expected response with message integrity")
+	}
+	auth := c.longTermAuth(addr)
+	if auth == nil || auth.realm != realm || auth.passwordAlgorithm != PasswordAlgorithmSHA256 {
+		t.Fatalf("expected cached challenge for realm %q using SHA256, got %+v", realm, auth)
+	}
+
+	// Stale nonce is replaced following a 438 response
+	ip := pc.LocalAddr().(*net.UDPAddr).IP
+	c.auth[addr.String()].nonce = s.Nonces.appendNonce(nil, nonceFeatures, ip, time.Now().Add(-time.Hour))
+	if _, err := c.Binding(context.Background(), addr); err != nil {
+		t.Fatalf("binding with stale nonce failed: %v", err)
+	}
+	if string(c.longTermAuth(addr).nonce) == string(auth.nonce) {
+		t.Fatal("expected nonce to be replaced")
+	}
+
+	// Nonce not issued by the server is replaced following a 401 response
+	c.auth[addr.String()].nonce = NewNonceManager([]byte("other"), 0).Nonce(nonceFeatures, pc.LocalAddr())
+	if _, err := c.Binding(context.Background(), addr); err != nil {
+		t.Fatalf("binding with invalid nonce failed: %v", err)
+	}
+
+	// Wrong password is rejected without retrying, as the 401 response carries the same nonce
+	nonce := s.Nonces.appendNonce(nil, nonceFeatures, ip, time.Now().Add(-10*time.Second))
+	c.auth[addr.String()].nonce = nonce
+	c.Password = "wrong"
+	_, err = c.Binding(context.Background(), addr)
+	if e, ok := err.(*ErrorResponse); !ok || e.Code != ErrorCodeUnauthenticated {
+		t.Fatalf("expected 401 error response with wrong password, got %v", err)
+	}
+	if string(c.longTermAuth(addr).nonce) != string(nonce) {
+		t.Fatal("expected nonce to be kept")
+	}
+}
+
+func TestClientWithoutCredentials(t *testing.T) {
+	s := Server{
+		Credentials: testCredentials{username: "user", realm: "example.org", password: testPassword},
+		Realm:       "example.org",
+	}
+	addr, shutdown := serveUDPTest(t, &s)
+	defer shutdown()
+
+	pc := listenTest(t)
+	defer pc.Close()
+
+	c := NewClient(pc)
+	defer c.Close()
+	r, err := c.Binding(context.Background(), addr)
+	if e, ok := err.(*ErrorResponse); !ok || e.Code != ErrorCodeUnauthenticated || e.Realm != "example.org" {
+		t.Fatalf("expected 401 error response, got %v", err)
+	}
+	if r == nil || r.Type() != TypeBindingError {
+		t.Fatal("expected error response returned with error")
+	}
+}
+
+// redirectHandler answers Binding requests with 300 Try Alternate to the address returned by to.
+func redirectHandler(to func() *net.UDPAddr, domain string) Handler {
+	return HandlerFunc(func(r *Request) *Builder {
+		b := r.NewErrorResponse(ErrorCodeTryAlternate, "Try Alternate")
+		addr := to()
+		b.SetAlternateServer(addr.IP.To4(), uint16(addr.Port))
+		if domain != "" {
+			b.SetAlternateDomain(domain)
+		}
+		return b
+	})
+}
+
+func TestClientRedirect(t *testing.T) {
+	var alternate Server
+	alternateAddr, shutdown := serveUDPTest(t, &alternate)
+	defer shutdown()
+
+	var s Server
+	s.Handle(MethodBinding, redirectHandler(func() *net.UDPAddr { return alternateAddr.(*net.UDPAddr) }, "stun.example.org"))
+	addr, shutdown := serveUDPTest(t, &s)
+	defer shutdown()
+
+	pc := listenTest(t)
+	defer pc.Close()
+
+	c := NewClient(pc)
+	defer c.Close()
+	r, err := c.Binding(context.Background(), addr)
+	if err != nil {
+		t.Fatalf("binding failed: %v", err)
+	}
+	if !sameAddr(r.From, alternateAddr) {
+		t.Fatalf("expected response from %v, got %v", alternateAddr, r.From)
+	}
+	if len(r.Redirects) != 1 {
+		t.Fatalf("expected 1 redirect, got %d", len(r.Redirects))
+	}
+	if rd := r.Redirects[0]; !sameAddr(rd.From, addr) || !sameAddr(rd.To, alternateAddr) || rd.Domain != "stun.example.org" {
+		t.Fatalf("unexpected redirect %+v", rd)
+	}
+}
+
+func TestClientRedirectLimits(t *testing.T) {
+	pcA, pcB := listenTest(t), listenTest(t)
+	defer pcA.Close()
+	defer pcB.Close()
+	aAddr, bAddr := pcA.LocalAddr().(*net.UDPAddr), pcB.LocalAddr().(*net.UDPAddr)
+
+	var a, b Server
+	a.Handle(MethodBinding, redirectHandler(func() *net.UDPAddr { return bAddr }, ""))
+	b.Handle(MethodBinding, redirectHandler(func() *net.UDPAddr { return aAddr }, ""))
+	go a.Serve(context.Background(), pcA)
+	go b.Serve(context.Background(), pcB)
+	defer a.Shutdown(context.Background())
+	defer b.Shutdown(context.Background())
+
+	pc := listenTest(t)
+	defer pc.Close()
+
+	c := NewClient(pc)
+	defer c.Close()
+	r, err := c.Binding(context.Background(), aAddr)
+	if err != ErrRedirectLoop {
+		t.Fatalf("expected ErrRedirectLoop, got %v", err)
+	}
+	if r == nil || len(r.Redirects) != 1 {
+		t.Fatal("expected final 300 response with the redirect followed")
+	}
+
+	c.MaxRedirects = 1
+	b.Handle(MethodBinding, redirectHandler(func() *net.UDPAddr { return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1} }, ""))
+	if _, err := c.Binding(context.Background(), aAddr); err != ErrTooManyRedirects {
+		t.Fatalf("expected ErrTooManyRedirects, got %v", err)
+	}
+}
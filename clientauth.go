@@ -0,0 +1,94 @@
+package stun
+
+import "net"
+
+// maxAuthAttempts bounds the requests Client.Do sends while authenticating, so a misbehaving server cannot keep it
+// retrying indefinitely.
+const maxAuthAttempts = 3
+
+// longTermAuth is the challenge a server issued in a 401 or 438 response, cached by Client per server.
+// See https://tools.ietf.org/html/rfc8489#section-9.2.5
+type longTermAuth struct {
+	realm              string
+	nonce              []byte
+	features           Features
+	passwordAlgorithm  PasswordAlgorithm
+	passwordAlgorithms []PasswordAlgorithm
+}
+
+// newLongTermAuth returns the challenge in the error response m, or false if m lacks a REALM or NONCE or offers no
+// supported password algorithm.
+func newLongTermAuth(m *Message) (*longTermAuth, bool) {
+	if len(m.realm) == 0 || len(m.nonce) == 0 {
+		return nil, false
+	}
+	passwordAlgorithm, err := m.SelectPasswordAlgorithm()
+	if err != nil {
+		return nil, false
+	}
+	return &longTermAuth{
+		realm:              string(m.realm),
+		nonce:              append([]byte(nil), m.nonce...),
+		features:           m.features,
+		passwordAlgorithm:  passwordAlgorithm,
+		passwordAlgorithms: m.PasswordAlgorithms(),
+	}, true
+}
+
+// apply adds the credential attributes to the request b, and sets the key both b and p use for message integrity.
+// Servers advertising FeaturePasswordAlgorithms implement RFC 8489 so are sent MESSAGE-INTEGRITY-SHA256, others
+// MESSAGE-INTEGRITY.
+func (a *longTermAuth) apply(b *Builder, p *Parser, username, password string) error {
+	b.SetUserWithSecurityFeatures(a.features, username, a.realm)
+	b.SetRealm(a.realm)
+	b.SetNonce(a.nonce)
+	if len(a.passwordAlgorithms) > 0 {
+		b.SetPasswordAlgorithms(a.passwordAlgorithms...)
+	}
+	b.SetKeyLongTerm(a.passwordAlgorithm, username, a.realm, password)
+	if a.features&FeaturePasswordAlgorithms != 0 {
+		b.AddMessageIntegritySHA256()
+	} else {
+		b.AddMessageIntegrity()
+	}
+	return p.SetKeyLongTerm(a.passwordAlgorithm, username, a.realm, password)
+}
+
+func (c *Client) longTermAuth(addr net.Addr) *longTermAuth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.auth[addr.String()]
+}
+
+// challenged updates the cached challenge for addr from the error response m to a request sent using used, returning
+// true if the request should be retried with the new challenge.
+// See https://tools.ietf.org/html/rfc8489#section-9.2.5
+func (c *Client) challenged(addr net.Addr, used *longTermAuth, m *Message) bool {
+	if c.Username == "" || m.has&hasErrorCode == 0 {
+		return false
+	}
+	switch m.errorCode {
+	case ErrorCodeUnauthenticated:
+		// Credentials were rejected unless the realm or nonce changed
+		if used != nil && used.realm == string(m.realm) && string(used.nonce) == string(m.nonce) {
+			return false
+		}
+	case ErrorCodeStaleNonce:
+		if used != nil && string(used.nonce) == string(m.nonce) {
+			return false
+		}
+	default:
+		return false
+	}
+	a, ok := newLongTermAuth(m)
+	if !ok {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.auth == nil {
+		c.auth = make(map[string]*longTermAuth)
+	}
+	c.auth[addr.String()] = a
+	return true
+}
@@ -0,0 +1,190 @@
+package stun
+
+import (
+	"context"
+	"net"
+)
+
+// Behavior is the mapping or filtering behavior of a NAT.
+// See https://tools.ietf.org/html/rfc4787#section-4
+type Behavior int
+
+const (
+	BehaviorUnknown Behavior = iota
+	BehaviorEndpointIndependent
+	BehaviorAddressDependent
+	BehaviorAddressAndPortDependent
+)
+
+func (b Behavior) String() string {
+	switch b {
+	case BehaviorEndpointIndependent:
+		return "endpoint independent"
+	case BehaviorAddressDependent:
+		return "address dependent"
+	case BehaviorAddressAndPortDependent:
+		return "address and port dependent"
+	}
+	return "unknown"
+}
+
+// NATBehavior is the result of Client.DiscoverNATBehavior.
+type NATBehavior struct {
+	// LocalAddr is the Client's transport address, and MappedAddr the server reflexive transport address the server
+	// received requests from.
+	LocalAddr  *net.UDPAddr
+	MappedAddr *net.UDPAddr
+	// NAT reports whether MappedAddr differs from LocalAddr, so the Client is behind a NAT.
+	NAT bool
+	// Mapping is the NAT's mapping behavior, BehaviorEndpointIndependent if not behind a NAT.
+	Mapping Behavior
+	// Filtering is the NAT's filtering behavior, or that of a firewall if not behind a NAT.
+	Filtering Behavior
+	// Hairpinning reports whether the NAT returns packets the Client sends to its own MappedAddr. False if not
+	// behind a NAT.
+	Hairpinning bool
+}
+
+// DiscoverNATBehavior classifies the NAT, if any, between the Client's Transport and the NAT behavior discovery
+// server at addr, by running the tests of RFC 5780 section 4. The filtering and hairpinning tests wait for their
+// requests to time out if unanswered, so ProbeTimeout, or otherwise RTO, Rc and Rm, determine how long discovery takes.
+// ErrBehaviorDiscoveryUnsupported is returned, along with the results so far, if the server does not report its
+// OTHER-ADDRESS, or ignores CHANGE-REQUEST.
+// See https://tools.ietf.org/html/rfc5780#section-4
+func (c *Client) DiscoverNATBehavior(ctx context.Context, addr *net.UDPAddr) (*NATBehavior, error) {
+	// Test I
+	r, err := c.behaviorBinding(ctx, addr, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	b := &NATBehavior{}
+	var ok bool
+	if b.MappedAddr, ok = r.mappedAddr(); !ok {
+		return nil, ErrNoMappedAddress
+	}
+	b.LocalAddr = localAddr(r.LocalAddr, addr)
+	b.NAT = b.LocalAddr == nil || !b.LocalAddr.IP.Equal(b.MappedAddr.IP) || b.LocalAddr.Port != b.MappedAddr.Port
+
+	ip, port, ok := r.OtherAddress()
+	if !ok {
+		return b, ErrBehaviorDiscoveryUnsupported
+	}
+	other := &net.UDPAddr{IP: append(net.IP(nil), ip...), Port: int(port)}
+
+	// Mapping behavior, tests II & III
+	// See https://tools.ietf.org/html/rfc5780#section-4.3
+	b.Mapping = BehaviorEndpointIndependent
+	if b.NAT {
+		r, err := c.behaviorBinding(ctx, &net.UDPAddr{IP: other.IP, Port: addr.Port}, nil, 0)
+		if err != nil {
+			return b, err
+		}
+		mapped, ok := r.mappedAddr()
+		if !ok {
+			return b, ErrNoMappedAddress
+		}
+		if !sameAddr(mapped, b.MappedAddr) {
+			if r, err = c.behaviorBinding(ctx, other, nil, 0); err != nil {
+				return b, err
+			}
+			mapped3, ok := r.mappedAddr()
+			if !ok {
+				return b, ErrNoMappedAddress
+			}
+			b.Mapping = BehaviorAddressAndPortDependent
+			if sameAddr(mapped3, mapped) {
+				b.Mapping = BehaviorAddressDependent
+			}
+		}
+	}
+
+	// Filtering behavior, tests II & III
+	// See https://tools.ietf.org/html/rfc5780#section-4.4
+	r, err = c.behaviorProbe(ctx, addr, func(b *Builder) { b.SetChangeRequest(true, true) }, txAnyAddr)
+	switch err {
+	case nil:
+		if from, ok := r.From.(*net.UDPAddr); !ok || from.IP.Equal(addr.IP) || from.Port == addr.Port {
+			return b, ErrBehaviorDiscoveryUnsupported
+		}
+		b.Filtering = BehaviorEndpointIndependent
+	case ErrTimeout:
+		r, err = c.behaviorProbe(ctx, addr, func(b *Builder) { b.SetChangeRequest(false, true) }, txAnyAddr)
+		switch err {
+		case nil:
+			if from, ok := r.From.(*net.UDPAddr); !ok || from.Port == addr.Port {
+				return b, ErrBehaviorDiscoveryUnsupported
+			}
+			b.Filtering = BehaviorAddressDependent
+		case ErrTimeout:
+			b.Filtering = BehaviorAddressAndPortDependent
+		default:
+			return b, err
+		}
+	default:
+		return b, err
+	}
+
+	// Hairpinning, the request sent to the mapped address is received by the Client itself if the NAT hairpins
+	// See https://tools.ietf.org/html/rfc5780#section-4.5
+	if b.NAT {
+		_, err := c.behaviorProbe(ctx, b.MappedAddr, nil, txAnyAddr|txEcho)
+		if err := ctx.Err(); err != nil {
+			return b, err
+		}
+		b.Hairpinning = err == nil
+	}
+	return b, nil
+}
+
+// behaviorProbe is behaviorBinding for requests that may go unanswered, waiting at most ProbeTimeout, if set, before
+// returning ErrTimeout.
+func (c *Client) behaviorProbe(ctx context.Context, addr *net.UDPAddr, build func(b *Builder), flags txFlags) (*Response, error) {
+	if c.ProbeTimeout <= 0 {
+		return c.behaviorBinding(ctx, addr, build, flags)
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, c.ProbeTimeout)
+	defer cancel()
+	r, err := c.behaviorBinding(probeCtx, addr, build, flags)
+	if err == context.DeadlineExceeded && ctx.Err() == nil {
+		return nil, ErrTimeout
+	}
+	return r, err
+}
+
+// behaviorBinding performs a Binding request to addr, with attributes added by build if not nil.
+func (c *Client) behaviorBinding(ctx context.Context, addr *net.UDPAddr, build func(b *Builder), flags txFlags) (*Response, error) {
+	r, _, err := c.authenticatedDo(ctx, addr, MethodBinding, build, flags)
+	if err != nil {
+		return nil, err
+	}
+	return r, r.Err()
+}
+
+// mappedAddr returns the XOR-MAPPED-ADDRESS, or MAPPED-ADDRESS if absent, of the response.
+func (r *Response) mappedAddr() (*net.UDPAddr, bool) {
+	ip, port, ok := r.XorMappedAddress()
+	if !ok {
+		if ip, port, ok = r.MappedAddress(); !ok {
+			return nil, false
+		}
+	}
+	return &net.UDPAddr{IP: append(net.IP(nil), ip...), Port: int(port)}, true
+}
+
+// localAddr returns the local transport address a response from to was received on. If the Transport is bound to
+// an unspecified address, the IP address is that of the interface routing to to.
+func localAddr(local net.Addr, to *net.UDPAddr) *net.UDPAddr {
+	a, ok := local.(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	if !a.IP.IsUnspecified() {
+		return a
+	}
+	conn, err := net.DialUDP("udp", nil, to)
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return &net.UDPAddr{IP: conn.LocalAddr().(*net.UDPAddr).IP, Port: a.Port}
+}
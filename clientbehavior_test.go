@@ -0,0 +1,158 @@
+package stun
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// natHandler answers Binding requests as if from behind a NAT, reporting the mapped address for the server socket
+// the request was received on, and dropping requests for which drop returns true.
+func natHandler(addrs, mapped [4]*net.UDPAddr, drop func(changeIP, changePort bool) bool) Handler {
+	return HandlerFunc(func(r *Request) *Builder {
+		changeIP, changePort, _ := r.Message.ChangeRequest()
+		if drop(changeIP, changePort) {
+			return nil
+		}
+		for i, addr := range addrs {
+			if sameAddr(r.LocalAddr, addr) {
+				b := r.NewResponse()
+				b.SetXorMappingAddress(mapped[i])
+				return b
+			}
+		}
+		return nil
+	})
+}
+
+// hairpinTest returns packets received back to the sender, as a NAT that hairpins would.
+func hairpinTest(t *testing.T) (*net.UDPAddr, func()) {
+	t.Helper()
+	pc := listenTest(t)
+	go func() {
+		buf := make([]byte, maxMessageSize)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], from)
+		}
+	}()
+	return pc.LocalAddr().(*net.UDPAddr), func() { pc.Close() }
+}
+
+func TestDiscoverNATBehaviorNoNAT(t *testing.T) {
+	var s Server
+	addrs, shutdown := serveBehaviorTest(t, &s)
+	defer shutdown()
+
+	pc := listenTest(t)
+	defer pc.Close()
+
+	c := NewClient(pc)
+	defer c.Close()
+	c.RTO, c.ProbeTimeout = 50*time.Millisecond, 200*time.Millisecond
+	b, err := c.DiscoverNATBehavior(context.Background(), addrs[0])
+	if err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	if b.NAT || b.Mapping != BehaviorEndpointIndependent || b.Filtering != BehaviorEndpointIndependent || b.Hairpinning {
+		t.Fatalf("expected no NAT with endpoint independent filtering, got %+v", b)
+	}
+	if !sameAddr(b.LocalAddr, pc.LocalAddr()) || !sameAddr(b.MappedAddr, pc.LocalAddr()) {
+		t.Fatalf("expected local and mapped address %s, got %s and %s", pc.LocalAddr(), b.LocalAddr, b.MappedAddr)
+	}
+}
+
+func TestDiscoverNATBehavior(t *testing.T) {
+	hairpin, stop := hairpinTest(t)
+	defer stop()
+	other := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 3), Port: 1}
+
+	tests := []struct {
+		name        string
+		mapped      [4]*net.UDPAddr
+		drop        func(changeIP, changePort bool) bool
+		mapping     Behavior
+		filtering   Behavior
+		hairpinning bool
+	}{
+		{
+			name:      "endpoint independent",
+			mapped:    [4]*net.UDPAddr{other, other, other, other},
+			drop:      func(changeIP, changePort bool) bool { return false },
+			mapping:   BehaviorEndpointIndependent,
+			filtering: BehaviorEndpointIndependent,
+		},
+		{
+			name:        "address dependent",
+			mapped:      [4]*net.UDPAddr{hairpin, hairpin, other, other},
+			drop:        func(changeIP, changePort bool) bool { return changeIP },
+			mapping:     BehaviorAddressDependent,
+			filtering:   BehaviorAddressDependent,
+			hairpinning: true,
+		},
+		{
+			name:      "address and port dependent",
+			mapped:    [4]*net.UDPAddr{other, other, {IP: other.IP, Port: 2}, {IP: other.IP, Port: 3}},
+			drop:      func(changeIP, changePort bool) bool { return changeIP || changePort },
+			mapping:   BehaviorAddressAndPortDependent,
+			filtering: BehaviorAddressAndPortDependent,
+		},
+	}
+	for _, tt := range tests {
+		var s Server
+		conns := listenBehaviorTest(t)
+		var addrs [4]*net.UDPAddr
+		for i, conn := range conns {
+			addrs[i] = conn.LocalAddr().(*net.UDPAddr)
+		}
+		s.Handle(MethodBinding, natHandler(addrs, tt.mapped, tt.drop))
+		errCh := make(chan error, 1)
+		go func() { errCh <- s.ServeBehaviorDiscovery(context.Background(), conns) }()
+
+		pc := listenTest(t)
+		c := NewClient(pc)
+		c.RTO, c.ProbeTimeout = 50*time.Millisecond, 200*time.Millisecond
+		b, err := c.DiscoverNATBehavior(context.Background(), addrs[0])
+		c.Close()
+		pc.Close()
+		s.Shutdown(context.Background())
+		<-errCh
+		for _, conn := range conns {
+			conn.Close()
+		}
+
+		if err != nil {
+			t.Fatalf("%s: discovery failed: %v", tt.name, err)
+		}
+		if !b.NAT || !sameAddr(b.MappedAddr, tt.mapped[0]) {
+			t.Fatalf("%s: expected NAT mapped to %s, got %+v", tt.name, tt.mapped[0], b)
+		}
+		if b.Mapping != tt.mapping || b.Filtering != tt.filtering || b.Hairpinning != tt.hairpinning {
+			t.Fatalf("%s: expected mapping %v, filtering %v, hairpinning %v, got %v, %v, %v", tt.name,
+				tt.mapping, tt.filtering, tt.hairpinning, b.Mapping, b.Filtering, b.Hairpinning)
+		}
+	}
+}
+
+func TestDiscoverNATBehaviorUnsupported(t *testing.T) {
+	var s Server
+	addr, shutdown := serveUDPTest(t, &s)
+	defer shutdown()
+
+	pc := listenTest(t)
+	defer pc.Close()
+
+	c := NewClient(pc)
+	defer c.Close()
+	b, err := c.DiscoverNATBehavior(context.Background(), addr.(*net.UDPAddr))
+	if err != ErrBehaviorDiscoveryUnsupported {
+		t.Fatalf("expected ErrBehaviorDiscoveryUnsupported, got %v", err)
+	}
+	if b == nil || b.NAT {
+		t.Fatalf("expected no NAT, got %+v", b)
+	}
+}
@@ -2,81 +2,151 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/renthraysk/stun"
 )
 
 func main() {
-	cfg := struct {
-		addr string
-	}{
-		addr: "127.0.0.1:3478",
+	if len(os.Args) > 1 && os.Args[1] == "nat" {
+		nat(os.Args[0]+" nat", os.Args[2:])
+		return
 	}
-	key := make([]byte, 16)
+
+	cfg := struct {
+		username string
+		password string
+		verbose  bool
+	}{}
 
 	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
-	flags.StringVar(&cfg.addr, "addr", cfg.addr, "addr")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [flags] [uri | domain]\n       %[1]s nat [flags] [uri | domain]\n\nuri defaults to stun:127.0.0.1\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&cfg.username, "user", "", "username for long term credentials")
+	flags.StringVar(&cfg.password, "password", "", "password for long term credentials")
+	flags.BoolVar(&cfg.verbose, "v", cfg.verbose, "dump response")
 	flags.Parse(os.Args[1:])
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	addr, err := net.ResolveUDPAddr("udp", cfg.addr)
+	u := parseURI(flags)
+	pc, err := net.ListenPacket("udp", ":0")
 	if err != nil {
-		log.Fatalf("ResolveUDPAddr failed: %v", err)
+		log.Fatalf("ListenPacket failed: %v", err)
 	}
-	conn, err := net.DialUDP("udp", nil, addr)
+	defer pc.Close()
+
+	// The Client reads from pc until closed, deferred so before pc is closed
+	c := stun.NewClient(pc)
+	defer c.Close()
+	c.Software = "test"
+	c.Username = cfg.username
+	c.Password = cfg.password
+	r, err := c.BindingURI(context.Background(), u)
 	if err != nil {
-		log.Fatalf("DialUDP failed: %v", err)
+		log.Fatalf("binding request failed: %v", err)
 	}
-	defer conn.Close()
-
-	errCh := make(chan error, 1)
-	go func(conn *net.UDPConn) {
-		errCh <- bindingRequest(ctx, conn, key)
-	}(conn)
+	for _, rd := range r.Redirects {
+		fmt.Fprintf(os.Stderr, "redirected from %s to %s", rd.From, rd.To)
+		if rd.Domain != "" {
+			fmt.Fprintf(os.Stderr, " (%s)", rd.Domain)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	if cfg.verbose {
+		fmt.Fprint(os.Stdout, r.String())
+	}
+	if ip, port, ok := r.XorMappedAddress(); ok {
+		fmt.Fprintf(os.Stdout, "%s\n", net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	} else if ip, port, ok := r.MappedAddress(); ok {
+		fmt.Fprintf(os.Stdout, "%s\n", net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	}
+}
 
-	select {
-	case <-ctx.Done():
-		log.Fatalf("cancelled")
-	case <-errCh:
+// parseURI returns the URI argument of flags, stun:127.0.0.1 if absent.
+func parseURI(flags *flag.FlagSet) *stun.URI {
+	uri := "stun:127.0.0.1"
+	if flags.NArg() > 0 {
+		uri = flags.Arg(0)
 	}
+	u, err := stun.ParseURI(uri)
+	if err != nil {
+		// Bare domain or host:port, servers discovered through DNS SRV records
+		if u, err = stun.ParseURI("stun:" + uri); err != nil {
+			log.Fatalf("invalid URI %q: %v", uri, err)
+		}
+	}
+	return u
 }
 
-func bindingRequest(ctx context.Context, conn *net.UDPConn, key []byte) error {
-	var in [1280]byte
-	var txID stun.TxID
+// nat classifies the NAT between this host and a NAT behavior discovery server.
+func nat(name string, args []string) {
+	cfg := struct {
+		username string
+		password string
+	}{}
 
-	if _, err := rand.Read(txID[:]); err != nil {
-		return err
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s [flags] [uri | domain]\n\nuri defaults to stun:127.0.0.1\n\n", name)
+		flags.PrintDefaults()
 	}
-	b := stun.New(stun.TypeBindingRequest, txID)
-	b.SetSoftware("test")
-	r, err := b.Build()
+	flags.StringVar(&cfg.username, "user", "", "username for long term credentials")
+	flags.StringVar(&cfg.password, "password", "", "password for long term credentials")
+	flags.Parse(args)
+
+	u := parseURI(flags)
+	ctx := context.Background()
+	endpoints, err := stun.LookupURI(ctx, nil, u)
 	if err != nil {
-		return err
+		log.Fatalf("lookup failed: %v", err)
 	}
-	if _, err := conn.Write(r); err != nil {
-		return err
+	var addr *net.UDPAddr
+	for _, e := range endpoints {
+		if e.Transport == stun.TransportUDP && !e.Secure {
+			addr = e.Addr().(*net.UDPAddr)
+			break
+		}
 	}
-	deadline, _ := ctx.Deadline()
-	if err := conn.SetReadDeadline(deadline); err != nil {
-		return err
+	if addr == nil {
+		log.Fatalf("no UDP endpoint for %s", u)
 	}
-	n, _, err := conn.ReadFrom(in[:])
+	pc, err := net.ListenPacket("udp", ":0")
 	if err != nil {
-		return err
+		log.Fatalf("ListenPacket failed: %v", err)
+	}
+	defer pc.Close()
+
+	// The Client reads from pc until closed, deferred so before pc is closed
+	c := stun.NewClient(pc)
+	defer c.Close()
+	c.Software = "test"
+	c.Username = cfg.username
+	c.Password = cfg.password
+	// Filtering and hairpinning tests expect some requests to go unanswered, so give up sooner than the defaults
+	c.ProbeTimeout = 3 * time.Second
+	b, err := c.DiscoverNATBehavior(ctx, addr)
+	if errors.Is(err, stun.ErrBehaviorDiscoveryUnsupported) {
+		// Results of the tests the server did support are still reported
+		fmt.Fprintf(os.Stderr, "warning: %v, results are incomplete\n", err)
+	} else if err != nil {
+		log.Fatalf("NAT behavior discovery failed: %v", err)
 	}
-	var p stun.Parser
-	var m stun.Message
-	if err := p.Parse(&m, in[:n:n]); err == nil {
-		_ = m
+	hairpinning := strconv.FormatBool(b.Hairpinning)
+	if err != nil {
+		hairpinning = "unknown"
 	}
-	return err
+	fmt.Fprintf(os.Stdout, "local address:  %s\n", b.LocalAddr)
+	fmt.Fprintf(os.Stdout, "mapped address: %s\n", b.MappedAddr)
+	fmt.Fprintf(os.Stdout, "NAT:            %t\n", b.NAT)
+	fmt.Fprintf(os.Stdout, "mapping:        %s\n", b.Mapping)
+	fmt.Fprintf(os.Stdout, "filtering:      %s\n", b.Filtering)
+	fmt.Fprintf(os.Stdout, "hairpinning:    %s\n", hairpinning)
 }
@@ -1,36 +1,189 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"time"
 
+	"github.com/pion/dtls/v2"
+	"github.com/pion/transport/v2/udp"
 	"github.com/renthraysk/stun"
 )
 
+var errUnknownUser = errors.New("unknown user")
+
+// user is a CredentialStore holding a single user.
+type user struct {
+	username string
+	realm    string
+	password string
+}
+
+func (u user) Password(username []byte) ([]byte, error) {
+	if string(username) != u.username {
+		return nil, errUnknownUser
+	}
+	return []byte(u.password), nil
+}
+
+func (u user) LongTermPassword(username, realm []byte) ([]byte, error) {
+	if string(username) != u.username || string(realm) != u.realm {
+		return nil, errUnknownUser
+	}
+	return []byte(u.password), nil
+}
+
+func (u user) LongTermPasswordByUserHash(userHash, realm []byte) ([]byte, []byte, error) {
+	h := sha256.Sum256([]byte(u.username + ":" + u.realm))
+	if !bytes.Equal(userHash, h[:]) || string(realm) != u.realm {
+		return nil, nil, errUnknownUser
+	}
+	return []byte(u.username), []byte(u.password), nil
+}
+
 func main() {
 
 	cfg := struct {
-		addr string
+		addr     string
+		altAddr  string
+		realm    string
+		username string
+		password string
+		secret   string
+		tlsAddr  string
+		dtlsAddr string
+		certFile string
+		keyFile  string
 	}{
-		addr: "127.0.0.1:3478",
+		addr:     "127.0.0.1:3478",
+		tlsAddr:  "127.0.0.1:5349",
+		dtlsAddr: "127.0.0.1:5349",
 	}
 
-	key := ""
-
 	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
 	flags.StringVar(&cfg.addr, "addr", cfg.addr, "addr")
+	flags.StringVar(&cfg.altAddr, "alt-addr", "", "alternate addr, differing from addr in IP and port, enables NAT behavior discovery")
+	flags.StringVar(&cfg.realm, "realm", "", "realm, enables long term credentials")
+	flags.StringVar(&cfg.username, "user", "", "username, enables authentication")
+	flags.StringVar(&cfg.password, "password", "", "password")
+	flags.StringVar(&cfg.secret, "nonce-secret", "", "secret shared by servers to accept each other's nonces")
+	flags.StringVar(&cfg.tlsAddr, "tls-addr", cfg.tlsAddr, "TLS addr, if -cert and -key are given")
+	flags.StringVar(&cfg.dtlsAddr, "dtls-addr", cfg.dtlsAddr, "DTLS addr, if -cert and -key are given")
+	flags.StringVar(&cfg.certFile, "cert", "", "TLS & DTLS certificate file")
+	flags.StringVar(&cfg.keyFile, "key", "", "TLS & DTLS key file")
 	flags.Parse(os.Args[1:])
 
 	pc, err := net.ListenPacket("udp", cfg.addr)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
+	defer pc.Close()
+	var behavior [4]net.PacketConn
+	if cfg.altAddr != "" {
+		behavior, err = listenBehaviorDiscovery(pc, cfg.altAddr)
+		if err != nil {
+			log.Fatalf("failed to listen: %v", err)
+		}
+		fmt.Fprintf(os.Stdout, "NAT behavior discovery alternate %s\n", behavior[3].LocalAddr().String())
+	}
+	l, err := net.Listen("tcp", cfg.addr)
+	if err != nil {
+		log.Fatalf("failed to listen: %v", err)
+	}
 
 	fmt.Fprintf(os.Stdout, "Listening on %s\n", pc.LocalAddr().String())
 
-	stun.Serve(pc, key)
+	srv := &stun.Server{ErrorLog: log.New(os.Stderr, "", log.LstdFlags)}
+	if cfg.username != "" {
+		srv.Credentials = user{username: cfg.username, realm: cfg.realm, password: cfg.password}
+		srv.Realm = cfg.realm
+	}
+	if cfg.secret != "" {
+		srv.Nonces = stun.NewNonceManager([]byte(cfg.secret), 0)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
 
+	n := 2
+	errCh := make(chan error, 4)
+	if behavior[0] != nil {
+		go func() { errCh <- srv.ServeBehaviorDiscovery(context.Background(), behavior) }()
+	} else {
+		go func() { errCh <- srv.Serve(context.Background(), pc) }()
+	}
+	go func() { errCh <- srv.ServeListener(context.Background(), l) }()
+	if cfg.certFile != "" && cfg.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.certFile, cfg.keyFile)
+		if err != nil {
+			log.Fatalf("failed to load certificate: %v", err)
+		}
+		tl, err := net.Listen("tcp", cfg.tlsAddr)
+		if err != nil {
+			log.Fatalf("failed to listen: %v", err)
+		}
+		fmt.Fprintf(os.Stdout, "Listening for TLS on %s\n", tl.Addr().String())
+		n++
+		go func() {
+			errCh <- srv.ServeTLS(context.Background(), tl, &tls.Config{Certificates: []tls.Certificate{cert}})
+		}()
+		laddr, err := net.ResolveUDPAddr("udp", cfg.dtlsAddr)
+		if err != nil {
+			log.Fatalf("invalid DTLS addr: %v", err)
+		}
+		dl, err := udp.Listen("udp", laddr)
+		if err != nil {
+			log.Fatalf("failed to listen: %v", err)
+		}
+		fmt.Fprintf(os.Stdout, "Listening for DTLS on %s\n", dl.Addr().String())
+		n++
+		go func() {
+			errCh <- srv.ServeDTLS(context.Background(), dl, &dtls.Config{Certificates: []tls.Certificate{cert}})
+		}()
+	}
+	for i := 0; i < n; i++ {
+		if err := <-errCh; err != stun.ErrServerClosed {
+			log.Fatalf("serve failed: %v", err)
+		}
+	}
+}
+
+// listenBehaviorDiscovery listens on the remaining combinations of the IP addresses and ports of pc and altAddr.
+func listenBehaviorDiscovery(pc net.PacketConn, altAddr string) ([4]net.PacketConn, error) {
+	var conns [4]net.PacketConn
+
+	alt, err := net.ResolveUDPAddr("udp", altAddr)
+	if err != nil {
+		return conns, err
+	}
+	primary := pc.LocalAddr().(*net.UDPAddr)
+	conns[0] = pc
+	addrs := []*net.UDPAddr{
+		{IP: primary.IP, Port: alt.Port},
+		{IP: alt.IP, Port: primary.Port},
+		{IP: alt.IP, Port: alt.Port},
+	}
+	for i, addr := range addrs {
+		if conns[i+1], err = net.ListenUDP("udp", addr); err != nil {
+			return conns, err
+		}
+	}
+	return conns, nil
 }
@@ -0,0 +1,76 @@
+package stun
+
+// CredentialStore provides passwords to a Parser for validating MessageIntegrity and MessageIntegritySHA256 attributes.
+// The username, userHash and realm arguments reference the message being parsed so should not be retained.
+// Returning an error causes the message integrity check to fail.
+// See https://tools.ietf.org/html/rfc8489#section-9
+type CredentialStore interface {
+	// Password returns the short term credential password for username.
+	// See https://tools.ietf.org/html/rfc8489#section-9.1
+	Password(username []byte) ([]byte, error)
+	// LongTermPassword returns the long term credential password for username within realm.
+	// See https://tools.ietf.org/html/rfc8489#section-9.2
+	LongTermPassword(username, realm []byte) ([]byte, error)
+	// LongTermPasswordByUserHash returns the username and long term credential password identified by the USERHASH
+	// attribute within realm.
+	// See https://tools.ietf.org/html/rfc8489#section-14.4
+	LongTermPasswordByUserHash(userHash, realm []byte) (username, password []byte, err error)
+}
+
+// keyGenerator derives the message integrity key from the credential related attributes of a message.
+type keyGenerator struct {
+	key               []byte
+	credentials       CredentialStore
+	username          []byte
+	realm             []byte
+	userHash          []byte
+	passwordAlgorithm PasswordAlgorithm
+}
+
+func (k *keyGenerator) Generate(b []byte) ([]byte, error) {
+	if len(k.key) > 0 {
+		return append(b, k.key...), nil
+	}
+	if k.credentials == nil {
+		return nil, ErrMissingMessageIntegrityKey
+	}
+
+	var (
+		username = k.username
+		password []byte
+		err      error
+	)
+
+	if len(k.userHash) != 0 {
+		if len(k.realm) == 0 {
+			return nil, ErrMissingRealm
+		}
+		username, password, err = k.credentials.LongTermPasswordByUserHash(k.userHash, k.realm)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if len(k.realm) == 0 {
+			password, err = k.credentials.Password(k.username)
+			if err != nil {
+				return nil, err
+			}
+			return append(b, password...), nil
+		}
+		if len(k.username) == 0 {
+			return nil, ErrMissingUsername
+		}
+		password, err = k.credentials.LongTermPassword(k.username, k.realm)
+		if err != nil {
+			return nil, err
+		}
+	}
+	switch k.passwordAlgorithm {
+	case PasswordAlgorithmMD5:
+		return appendLongTermKeyMD5(b, username, k.realm, password), nil
+	case PasswordAlgorithmSHA256:
+		return appendLongTermKeySHA256(b, username, k.realm, password), nil
+	default:
+		return nil, ErrUnknownPasswordAlgorithm
+	}
+}
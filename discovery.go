@@ -0,0 +1,163 @@
+package stun
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// Resolver performs the DNS lookups used to discover servers. *net.Resolver implements Resolver.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// Endpoint is a transport address of a server, discovered from a URI by LookupURI.
+type Endpoint struct {
+	// Transport is TransportUDP or TransportTCP.
+	Transport string
+	// Secure is true for TLS over TCP, or DTLS over UDP.
+	Secure bool
+	IP     net.IP
+	Port   int
+	// ServerName is the host of the URI, which a secure server's certificate is validated against.
+	ServerName string
+}
+
+// Addr returns the address of the endpoint for use with the transport.
+func (e Endpoint) Addr() net.Addr {
+	if e.Transport == TransportTCP {
+		if e.Secure {
+			return &TLSAddr{TCPAddr: net.TCPAddr{IP: e.IP, Port: e.Port}, ServerName: e.ServerName}
+		}
+		return &net.TCPAddr{IP: e.IP, Port: e.Port}
+	}
+	if e.Secure {
+		return &DTLSAddr{UDPAddr: net.UDPAddr{IP: e.IP, Port: e.Port}, ServerName: e.ServerName}
+	}
+	return &net.UDPAddr{IP: e.IP, Port: e.Port}
+}
+
+func (e Endpoint) String() string {
+	s := e.Transport
+	if e.Secure {
+		s += "+secure"
+	}
+	return s + " " + net.JoinHostPort(e.IP.String(), strconv.Itoa(e.Port))
+}
+
+// LookupURI returns the endpoints of the server identified by the URI, in the order they should be tried.
+// If the URI has neither a port nor an IP address, SRV records are looked up for each of the transports the scheme
+// permits, _stun._udp & _stun._tcp for stun, _stuns._tcp & _stuns._udp for stuns, and likewise for turn and turns,
+// ordered by priority and weight. Otherwise, or if there are no SRV records, the host's A and AAAA records are used
+// with the URI's port or the default port. ErrUnsupportedTransport is returned if the URI's transport is neither udp
+// nor tcp.
+// See https://tools.ietf.org/html/rfc8489#section-8 & https://tools.ietf.org/html/rfc2782
+func LookupURI(ctx context.Context, r Resolver, u *URI) ([]Endpoint, error) {
+	var endpoints []Endpoint
+	var err error
+
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	transports := []string{TransportUDP, TransportTCP}
+	if u.Secure() {
+		transports = []string{TransportTCP, TransportUDP}
+	}
+	if u.Transport != "" {
+		if u.Transport != TransportUDP && u.Transport != TransportTCP {
+			return nil, ErrUnsupportedTransport
+		}
+		transports = []string{u.Transport}
+	}
+	host, port := u.Host, u.Port
+	if port == 0 {
+		port = DefaultPort
+		if u.Secure() {
+			port = DefaultSecurePort
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, t := range transports {
+			endpoints = append(endpoints, Endpoint{Transport: t, Secure: u.Secure(), IP: ip, Port: port, ServerName: host})
+		}
+		return endpoints, nil
+	}
+
+	var ips []net.IPAddr
+	for _, t := range transports {
+		var srvs []*net.SRV
+		if u.Port == 0 {
+			_, srvs, _ = r.LookupSRV(ctx, u.Scheme, t, host)
+		}
+		if len(srvs) == 0 {
+			if ips == nil {
+				if ips, err = r.LookupIPAddr(ctx, host); err != nil {
+					continue
+				}
+			}
+			for _, ip := range ips {
+				endpoints = append(endpoints, Endpoint{Transport: t, Secure: u.Secure(), IP: ip.IP, Port: port, ServerName: host})
+			}
+			continue
+		}
+		for _, srv := range orderSRV(srvs, rand.Intn) {
+			// A target of "." means the service is not available
+			if srv.Target == "." {
+				break
+			}
+			var targetIPs []net.IPAddr
+			if targetIPs, err = r.LookupIPAddr(ctx, srv.Target); err != nil {
+				continue
+			}
+			for _, ip := range targetIPs {
+				endpoints = append(endpoints, Endpoint{Transport: t, Secure: u.Secure(), IP: ip.IP, Port: int(srv.Port), ServerName: host})
+			}
+		}
+	}
+	if len(endpoints) == 0 {
+		if err == nil {
+			err = &net.DNSError{Err: "no addresses found", Name: host, IsNotFound: true}
+		}
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+// orderSRV sorts SRV records by priority, and within each priority orders them by a weighted random selection using
+// intn, which returns a random int in [0,n).
+// See https://tools.ietf.org/html/rfc2782
+func orderSRV(srvs []*net.SRV, intn func(n int) int) []*net.SRV {
+	srvs = append([]*net.SRV(nil), srvs...)
+	// Zero weight records first within each priority, giving them a small chance of selection
+	sort.SliceStable(srvs, func(i, j int) bool {
+		if srvs[i].Priority != srvs[j].Priority {
+			return srvs[i].Priority < srvs[j].Priority
+		}
+		return srvs[i].Weight == 0 && srvs[j].Weight != 0
+	})
+	for i := 0; i < len(srvs); {
+		j := i + 1
+		for j < len(srvs) && srvs[j].Priority == srvs[i].Priority {
+			j++
+		}
+		for ; i < j-1; i++ {
+			sum := 0
+			for _, srv := range srvs[i:j] {
+				sum += int(srv.Weight)
+			}
+			n := intn(sum + 1)
+			k := i
+			for sum = int(srvs[k].Weight); sum < n; sum += int(srvs[k].Weight) {
+				k++
+			}
+			srv := srvs[k]
+			copy(srvs[i+1:k+1], srvs[i:k])
+			srvs[i] = srv
+		}
+		i = j
+	}
+	return srvs
+}
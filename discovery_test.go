@@ -0,0 +1,166 @@
+package stun
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+// testResolver is an in-process Resolver answering from maps of SRV and address records.
+type testResolver struct {
+	srv  map[string][]*net.SRV
+	host map[string][]net.IPAddr
+}
+
+func (r *testResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	cname := "_" + service + "._" + proto + "." + name
+	srvs, ok := r.srv[cname]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: cname, IsNotFound: true}
+	}
+	return cname, srvs, nil
+}
+
+func (r *testResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r.host[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+func TestLookupURISRV(t *testing.T) {
+	r := &testResolver{
+		srv: map[string][]*net.SRV{
+			"_stun._udp.example.org": {
+				{Target: "b.example.org", Port: 3479, Priority: 20, Weight: 0},
+				{Target: "a.example.org", Port: 3478, Priority: 10, Weight: 1},
+			},
+			"_stuns._tcp.example.org": {
+				{Target: "a.example.org", Port: 443, Priority: 10},
+			},
+		},
+		host: map[string][]net.IPAddr{
+			"example.org":   {{IP: net.IPv4(192, 0, 2, 100)}},
+			"a.example.org": {{IP: net.IPv4(192, 0, 2, 1)}, {IP: net.ParseIP("2001:db8::1")}},
+			"b.example.org": {{IP: net.IPv4(192, 0, 2, 2)}},
+		},
+	}
+
+	u := &URI{Scheme: SchemeSTUN, Host: "example.org"}
+	endpoints, err := LookupURI(context.Background(), r, u)
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	expected := []string{
+		"udp 192.0.2.1:3478",
+		"udp [2001:db8::1]:3478",
+		"udp 192.0.2.2:3479",
+		// No _stun._tcp SRV records, so falls back to A/AAAA with the default port
+		"tcp 192.0.2.100:3478",
+	}
+	if len(endpoints) != len(expected) {
+		t.Fatalf("expected %d endpoints, got %v", len(expected), endpoints)
+	}
+	for i, e := range endpoints {
+		if e.String() != expected[i] {
+			t.Fatalf("expected endpoint %d %s, got %s", i, expected[i], e)
+		}
+	}
+
+	u = &URI{Scheme: SchemeSTUNS, Host: "example.org"}
+	if endpoints, err = LookupURI(context.Background(), r, u); err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if e := endpoints[0]; e.String() != "tcp+secure 192.0.2.1:443" || e.ServerName != "example.org" {
+		t.Fatalf("unexpected first endpoint %s for %s", e, u)
+	}
+
+	// Explicit ports bypass SRV lookup
+	u = &URI{Scheme: SchemeSTUN, Host: "example.org", Port: 1234, Transport: TransportUDP}
+	if endpoints, err = LookupURI(context.Background(), r, u); err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if len(endpoints) != 1 || endpoints[0].String() != "udp 192.0.2.100:1234" {
+		t.Fatalf("unexpected endpoints %v", endpoints)
+	}
+
+	u = &URI{Scheme: SchemeSTUN, Host: "missing.example.org"}
+	if _, err := LookupURI(context.Background(), r, u); err == nil {
+		t.Fatal("expected error for missing host")
+	}
+
+	// Errors looking up SRV targets are returned
+	r.srv["_stun._udp.missing.example.org"] = []*net.SRV{{Target: "a.missing.example.org", Port: 3478}}
+	u = &URI{Scheme: SchemeSTUN, Host: "missing.example.org", Transport: TransportUDP}
+	if _, err := LookupURI(context.Background(), r, u); !isDNSError(err, "a.missing.example.org") {
+		t.Fatalf("expected error looking up SRV target, got %v", err)
+	}
+
+	for _, host := range []string{"example.org", "192.0.2.1"} {
+		u = &URI{Scheme: SchemeTURN, Host: host, Transport: "sctp"}
+		if _, err := LookupURI(context.Background(), r, u); err != ErrUnsupportedTransport {
+			t.Fatalf("expected ErrUnsupportedTransport for %s, got %v", u, err)
+		}
+	}
+}
+
+func TestOrderSRV(t *testing.T) {
+	srvs := []*net.SRV{
+		{Target: "c", Priority: 2, Weight: 10},
+		{Target: "a", Priority: 1, Weight: 0},
+		{Target: "b", Priority: 1, Weight: 10},
+		{Target: "d", Priority: 2, Weight: 30},
+	}
+	order := func(intn func(int) int) string {
+		var s string
+		for _, srv := range orderSRV(srvs, intn) {
+			s += srv.Target
+		}
+		return s
+	}
+	// Lowest random number selects zero weight records first
+	if s := order(func(int) int { return 0 }); s != "abcd" {
+		t.Fatalf("expected abcd, got %s", s)
+	}
+	// Highest random number selects the last record by running sum
+	if s := order(func(n int) int { return n - 1 }); s != "badc" {
+		t.Fatalf("expected badc, got %s", s)
+	}
+	if srvs[0].Target != "c" {
+		t.Fatal("expected orderSRV not to modify its argument")
+	}
+}
+
+func TestClientBindingURIResolver(t *testing.T) {
+	var s Server
+	addr, shutdown := serveUDPTest(t, &s)
+	defer shutdown()
+
+	pc := listenTest(t)
+	defer pc.Close()
+
+	c := NewClient(pc)
+	defer c.Close()
+	c.Resolver = &testResolver{
+		srv: map[string][]*net.SRV{
+			"_stun._udp.example.org": {{Target: "stun.example.org", Port: uint16(addr.(*net.UDPAddr).Port)}},
+		},
+		host: map[string][]net.IPAddr{
+			"stun.example.org": {{IP: net.IPv4(127, 0, 0, 1)}},
+		},
+	}
+	r, err := c.BindingURI(context.Background(), &URI{Scheme: SchemeSTUN, Host: "example.org"})
+	if err != nil {
+		t.Fatalf("binding failed: %v", err)
+	}
+	if !sameAddr(r.From, addr) {
+		t.Fatalf("expected response from %v, got %v", addr, r.From)
+	}
+}
+
+// isDNSError reports whether err is a *net.DNSError for name.
+func isDNSError(err error, name string) bool {
+	de, ok := err.(*net.DNSError)
+	return ok && de.Name == name
+}
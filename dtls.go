@@ -0,0 +1,78 @@
+package stun
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/pion/dtls/v2"
+)
+
+// dtlsIdleTimeout is how long the Server keeps a DTLS association without receiving a request on it.
+const dtlsIdleTimeout = 5 * time.Minute
+
+// DTLSAddr is the address of a server reached over DTLS, with the name its certificate is validated against.
+type DTLSAddr struct {
+	net.UDPAddr
+	ServerName string
+}
+
+func (a *DTLSAddr) Network() string { return "dtls" }
+func (a *DTLSAddr) String() string  { return a.UDPAddr.String() }
+
+// ServeDTLS performs the DTLS handshake with each connection accepted from l, such as those of a pion/transport
+// udp.Listener, and answers the requests it receives, see ServeListener. config must contain at least one
+// certificate, and may set a SessionStore to permit session resumption. If config.SupportedProtocols is empty
+// ALPNNATDiscovery and ALPNTURN are offered. Associations idle for 5 minutes are closed.
+// See https://tools.ietf.org/html/rfc7350 & https://tools.ietf.org/html/rfc8489#section-6.2.1
+func (s *Server) ServeDTLS(ctx context.Context, l net.Listener, config *dtls.Config) error {
+	cfg := *config
+	if len(cfg.SupportedProtocols) == 0 {
+		cfg.SupportedProtocols = []string{ALPNNATDiscovery, ALPNTURN}
+	}
+	// Interrupted by closing, as the DTLS connection's read deadline is distinct from that of the connection beneath
+	interrupt := func(conn net.Conn) { conn.Close() }
+	return s.serveListener(ctx, l, interrupt, func(ctx context.Context, conn net.Conn) {
+		dc, err := dtls.ServerWithContext(ctx, conn, &cfg)
+		if err != nil {
+			if !s.shuttingDown() {
+				s.logf("stun: DTLS handshake with %s: %v", conn.RemoteAddr(), err)
+			}
+			conn.Close()
+			return
+		}
+		s.serveConn(ctx, dc, newDatagramTransport(dc, dtlsIdleTimeout))
+	})
+}
+
+// dtlsConfig returns the configuration for a DTLS connection to addr.
+func (c *Client) dtlsConfig(addr *DTLSAddr) *dtls.Config {
+	config := &dtls.Config{}
+	if c.DTLSConfig != nil {
+		cfg := *c.DTLSConfig
+		config = &cfg
+	}
+	if addr.ServerName != "" {
+		config.ServerName = addr.ServerName
+	}
+	if len(config.SupportedProtocols) == 0 {
+		config.SupportedProtocols = []string{ALPNNATDiscovery}
+	}
+	return config
+}
+
+// dialDTLS dials addr with d, and performs the DTLS handshake, which must complete within Ti.
+func (c *Client) dialDTLS(ctx context.Context, d *net.Dialer, addr *DTLSAddr) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.ti())
+	defer cancel()
+	conn, err := d.DialContext(ctx, "udp", addr.String())
+	if err != nil {
+		return nil, err
+	}
+	dc, err := dtls.ClientWithContext(ctx, conn, c.dtlsConfig(addr))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return dc, nil
+}
@@ -0,0 +1,84 @@
+package stun
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/transport/v2/udp"
+)
+
+// listenDTLSTest returns a loopback UDP listener to serve DTLS on.
+func listenDTLSTest(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := udp.Listen("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	return l
+}
+
+func TestClientDTLS(t *testing.T) {
+	cert, pool := testCertificate(t, "stun.example.org")
+
+	var s Server
+	l := listenDTLSTest(t)
+	addr, shutdown := serveTest(t, &s, l.Addr(), func(ctx context.Context) error {
+		return s.ServeDTLS(ctx, l, &dtls.Config{Certificates: []tls.Certificate{cert}})
+	})
+	defer shutdown()
+
+	c := NewClient(nil)
+	defer c.Close()
+	c.DTLSConfig = &dtls.Config{RootCAs: pool}
+
+	for i := 0; i < 2; i++ {
+		r, err := c.Binding(context.Background(), &DTLSAddr{UDPAddr: *addr.(*net.UDPAddr), ServerName: "stun.example.org"})
+		if err != nil {
+			t.Fatalf("binding failed: %v", err)
+		}
+		if _, _, ok := r.XorMappedAddress(); !ok {
+			t.Fatal("expected xor mapped address")
+		}
+	}
+	if len(c.streams) != 1 {
+		t.Fatalf("expected 1 DTLS connection, got %d", len(c.streams))
+	}
+
+	if _, err := c.Binding(context.Background(), &DTLSAddr{UDPAddr: *addr.(*net.UDPAddr), ServerName: "other.example.org"}); err == nil {
+		t.Fatal("expected certificate validation to fail for other.example.org")
+	}
+}
+
+func TestClientBindingURIDTLS(t *testing.T) {
+	cert, pool := testCertificate(t, "stun.example.org")
+
+	var s Server
+	l := listenDTLSTest(t)
+	addr, shutdown := serveTest(t, &s, l.Addr(), func(ctx context.Context) error {
+		return s.ServeDTLS(ctx, l, &dtls.Config{Certificates: []tls.Certificate{cert}})
+	})
+	defer shutdown()
+
+	c := NewClient(nil)
+	defer c.Close()
+	c.DTLSConfig = &dtls.Config{RootCAs: pool}
+	c.Resolver = &testResolver{
+		srv: map[string][]*net.SRV{
+			"_stuns._udp.stun.example.org": {{Target: "host.example.org", Port: uint16(addr.(*net.UDPAddr).Port)}},
+		},
+		host: map[string][]net.IPAddr{
+			"host.example.org": {{IP: net.IPv4(127, 0, 0, 1)}},
+		},
+	}
+	u := &URI{Scheme: SchemeSTUNS, Host: "stun.example.org"}
+	r, err := c.BindingURI(context.Background(), u)
+	if err != nil {
+		t.Fatalf("binding failed: %v", err)
+	}
+	if _, ok := r.From.(*net.UDPAddr); !ok {
+		t.Fatalf("expected response over UDP, got %v", r.From)
+	}
+}
@@ -0,0 +1,225 @@
+package stun
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Dump writes an annotated listing of the raw STUN message to w, in the style of the RFC 5769 test vectors.
+// Malformed messages are listed as far as possible. As no key is available MESSAGE-INTEGRITY and
+// MESSAGE-INTEGRITY-SHA256 attributes are reported as unverified.
+func Dump(w io.Writer, raw []byte) error {
+	if len(raw) < headerSize {
+		return ErrNotASTUNMessage
+	}
+	_, err := w.Write(appendDump(nil, raw, false))
+	return err
+}
+
+// String returns an annotated listing of the message, see Dump. As the message was successfully parsed any
+// MESSAGE-INTEGRITY and MESSAGE-INTEGRITY-SHA256 attributes are reported as verified.
+func (m *Message) String() string {
+	if len(m.raw) < headerSize {
+		return ""
+	}
+	return string(appendDump(nil, m.raw, true))
+}
+
+func appendDump(b []byte, raw []byte, verified bool) []byte {
+	t := Type(binary.BigEndian.Uint16(raw[:2]))
+	b = appendDumpLines(b, raw[:4], t.String()+" type and message length "+strconv.Itoa(int(binary.BigEndian.Uint16(raw[2:4]))))
+	if binary.BigEndian.Uint32(raw[4:8]) == magicCookie {
+		b = appendDumpLines(b, raw[4:8], "Magic cookie")
+	} else {
+		b = appendDumpLines(b, raw[4:8], "Magic cookie (invalid)")
+	}
+	b = appendDumpLines(b, raw[8:headerSize], "Transaction ID")
+
+	off := headerSize
+	for len(raw)-off >= 4 {
+		attrs := raw[off:]
+		attrType, attrSize := attributeType(attrs), attributeSize(attrs)
+		b = appendDumpLines(b, attrs[:4], attrType.String()+" attribute header")
+		attrValue := attrs[4:]
+		padded := (attrSize + 3) &^ 3
+		if len(attrValue) < padded {
+			return appendDumpLines(b, attrValue, "Truncated value, expected "+strconv.Itoa(attrSize)+" bytes")
+		}
+		s := describeAttribute(raw[:off], attrType, attrValue[:attrSize], verified)
+		if n := padded - attrSize; n > 0 {
+			s += " and padding (" + strconv.Itoa(n) + " bytes)"
+		}
+		b = appendDumpLines(b, attrValue[:padded], s)
+		off += 4 + padded
+	}
+	if off < len(raw) {
+		b = appendDumpLines(b, raw[off:], "Trailing bytes")
+	}
+	return b
+}
+
+// appendDumpLines appends the bytes in hex, 4 per line. Descriptions of values spanning multiple lines are placed on
+// the middle line, with all lines bracketed.
+func appendDumpLines(b []byte, value []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+
+	n := (len(value) + 3) / 4
+	for i := 0; i < n; i++ {
+		line := value[4*i:]
+		if len(line) > 4 {
+			line = line[:4]
+		}
+		for j, x := range line {
+			if j > 0 {
+				b = append(b, ' ')
+			}
+			b = append(b, hex[x>>4], hex[x&0x0F])
+		}
+		for j := len(line); j < 4; j++ {
+			b = append(b, "   "...)
+		}
+		switch {
+		case n == 1:
+			b = append(b, "     "...)
+			b = append(b, s...)
+		case i == (n-1)/2:
+			b = append(b, "  }  "...)
+			b = append(b, s...)
+		default:
+			b = append(b, "  }"...)
+		}
+		b = append(b, '\n')
+	}
+	return b
+}
+
+// describeAttribute returns a description of the attribute value. raw spans the header and all attributes preceding
+// this one.
+func describeAttribute(raw []byte, attrType Attr, attrValue []byte, verified bool) string {
+	switch attrType {
+	case AttrMappedAddress, AttrXorMappedAddress, AttrAlternateServer, AttrResponseOrigin, AttrOtherAddress:
+		var a Address
+		if err := a.Unmarshal(raw, attrType, attrValue); err != nil {
+			return "Address, " + err.Error()
+		}
+		return "Address " + net.JoinHostPort(a.IP.String(), strconv.Itoa(int(a.Port)))
+
+	case AttrUsername:
+		return "Username " + strconv.Quote(string(attrValue))
+
+	case AttrRealm:
+		return "Realm " + strconv.Quote(string(attrValue))
+
+	case AttrSoftware:
+		return "Software " + strconv.Quote(string(attrValue))
+
+	case AttrAlternateDomain:
+		return "Domain " + strconv.Quote(string(attrValue))
+
+	case AttrNonce:
+		s := "Nonce " + strconv.Quote(string(attrValue))
+		if len(attrValue) >= len(nonceSecurityFeaturesPrefix)+4 &&
+			string(attrValue[:len(nonceSecurityFeaturesPrefix)]) == nonceSecurityFeaturesPrefix {
+			if f, ok := decodeSecurityFeatures(attrValue[len(nonceSecurityFeaturesPrefix):]); ok {
+				s += ", security features 0x" + strconv.FormatUint(uint64(f), 16)
+			}
+		}
+		return s
+
+	case AttrUserHash:
+		return "Userhash value (" + strconv.Itoa(len(attrValue)) + " bytes)"
+
+	case AttrErrorCode:
+		if len(attrValue) < 4 {
+			return "Error code, malformed"
+		}
+		errorCode, ok := errorCodeFromAttribute(attrValue)
+		if !ok {
+			return "Error code, invalid class or number"
+		}
+		return "Error code " + strconv.Itoa(int(errorCode)) + ", reason " + strconv.Quote(string(attrValue[4:]))
+
+	case AttrUnknownAttributes:
+		s := "Unknown attributes"
+		for i := 0; i+2 <= len(attrValue); i += 2 {
+			if i > 0 {
+				s += ","
+			}
+			s += " " + Attr(binary.BigEndian.Uint16(attrValue[i:])).String()
+		}
+		return s
+
+	case AttrPasswordAlgorithm:
+		if len(attrValue) < 4 {
+			return "Password algorithm, malformed"
+		}
+		return "Password algorithm " + PasswordAlgorithm(binary.BigEndian.Uint16(attrValue)).String()
+
+	case AttrPasswordAlgorithms:
+		if !validPasswordAlgorithms(attrValue) {
+			return "Password algorithms, malformed"
+		}
+		s := "Password algorithms"
+		for v := attrValue; len(v) >= 4; v = v[4+(attributeSize(v)+3)&^3:] {
+			if len(v) < len(attrValue) {
+				s += ","
+			}
+			s += " " + PasswordAlgorithm(binary.BigEndian.Uint16(v)).String()
+		}
+		return s
+
+	case AttrChangeRequest:
+		if len(attrValue) != 4 {
+			return "Change request, malformed"
+		}
+		flags := binary.BigEndian.Uint32(attrValue)
+		return "Change request, change IP " + strconv.FormatBool(flags&changeIPFlag != 0) + ", change port " +
+			strconv.FormatBool(flags&changePortFlag != 0)
+
+	case AttrResponsePort:
+		if len(attrValue) != 4 {
+			return "Response port, malformed"
+		}
+		return "Response port " + strconv.Itoa(int(binary.BigEndian.Uint16(attrValue)))
+
+	case AttrPadding:
+		return "Padding (" + strconv.Itoa(len(attrValue)) + " bytes)"
+
+	case AttrPriority:
+		if len(attrValue) != 4 {
+			return "ICE priority, malformed"
+		}
+		return "ICE priority value " + strconv.FormatUint(uint64(binary.BigEndian.Uint32(attrValue)), 10)
+
+	case AttrICEControlled, AttrICEControlling:
+		if len(attrValue) != 8 {
+			return "Tie breaker, malformed"
+		}
+		return "Tie breaker 0x" + strconv.FormatUint(binary.BigEndian.Uint64(attrValue), 16)
+
+	case AttrMessageIntegrity:
+		return "HMAC-SHA1 fingerprint" + verifiedString(verified)
+
+	case AttrMessageIntegritySHA256:
+		return "HMAC-SHA256 value (" + strconv.Itoa(len(attrValue)) + " bytes)" + verifiedString(verified)
+
+	case AttrFingerprint:
+		if len(attrValue) != 4 {
+			return "CRC32 fingerprint, malformed"
+		}
+		if validateFingerprint(raw, binary.BigEndian.Uint32(attrValue)) {
+			return "CRC32 fingerprint, valid"
+		}
+		return "CRC32 fingerprint, invalid"
+	}
+	return "Value (" + strconv.Itoa(len(attrValue)) + " bytes)"
+}
+
+func verifiedString(verified bool) string {
+	if verified {
+		return ", verified"
+	}
+	return ", unverified"
+}
@@ -1,5 +1,10 @@
 package stun
 
+import (
+	"net"
+	"strconv"
+)
+
 type errorString string
 
 func (e errorString) Error() string { return string(e) }
@@ -30,7 +35,69 @@ const (
 	ErrMissingMessageIntegrityKey          = errorString("missing message integrity key")
 	ErrUnknownAddressAttribute             = errorString("unknown address attribute")
 	ErrUnknownIPFamily                     = errorString("unknown IP family")
+	ErrMissingErrorCode                    = errorString("missing error code")
+	ErrMissingPasswordAlgorithm            = errorString("missing password algorithm")
+	ErrAttributeNotAllowed                 = errorString("attribute not allowed in message class")
+	ErrDuplicateAttribute                  = errorString("duplicate attribute")
+	ErrMissingPasswordAlgorithms           = errorString("missing password algorithms")
+	ErrPasswordAlgorithmsMismatch          = errorString("password algorithms do not match those offered")
+	ErrPasswordAlgorithmNotOffered         = errorString("password algorithm not offered")
+	ErrInvalidURI                          = errorString("invalid URI")
+	ErrUnknownScheme                       = errorString("unknown URI scheme")
+	ErrStaleNonce                          = errorString("stale nonce")
+	ErrInvalidNonce                        = errorString("invalid nonce")
+	ErrPaddingTooLong                      = errorString("padding too long")
+
+	ErrServerClosed = errorString("server closed")
+	ErrClientClosed = errorString("client closed")
+	ErrTimeout      = errorString("transaction timed out")
+
+	ErrTooManyRedirects = errorString("too many redirects")
+	ErrRedirectLoop     = errorString("redirect loop")
+
+	ErrUnsupportedTransport = errorString("unsupported transport")
+
+	ErrBehaviorDiscoveryAddrs       = errorString("NAT behavior discovery requires sockets on two IP addresses and two ports")
+	ErrBehaviorDiscoveryUnsupported = errorString("server does not support NAT behavior discovery")
+	ErrNoMappedAddress              = errorString("response has no mapped address")
 
 	ErrKeySet     = errorString("key already set previously")
 	ErrKeyNotUsed = errorString("key set but no messageintegrity or messageintegritysha256 attributes used")
 )
+
+// AttributeError is returned by Builder.Build when an attribute is duplicated or not permitted in the class of
+// message being built. Err is either ErrDuplicateAttribute or ErrAttributeNotAllowed.
+type AttributeError struct {
+	Attr  Attr
+	Class Class
+	Err   error
+}
+
+func (e *AttributeError) Error() string {
+	return e.Err.Error() + ": " + e.Attr.String() + " in " + e.Class.String()
+}
+
+func (e *AttributeError) Unwrap() error { return e.Err }
+
+// ErrorResponse is the error returned by Message.Err for STUN error responses, carrying the attributes a client needs
+// to decide whether to retry with credentials, follow a redirect, or give up.
+// See https://tools.ietf.org/html/rfc8489#section-6.3.4
+type ErrorResponse struct {
+	Code   ErrorCode
+	Reason string
+	// UnknownAttributes lists the attributes the server did not understand, with ErrorCodeUnknownAttribute.
+	UnknownAttributes []Attr
+	// AlternateServer and AlternateDomain, with ErrorCodeTryAlternate.
+	AlternateServer *net.UDPAddr
+	AlternateDomain string
+	// Realm and Nonce, with ErrorCodeUnauthenticated or ErrorCodeStaleNonce.
+	Realm string
+	Nonce []byte
+}
+
+func (e *ErrorResponse) Error() string {
+	if e.Reason == "" {
+		return "error response " + strconv.Itoa(int(e.Code))
+	}
+	return "error response " + strconv.Itoa(int(e.Code)) + " " + e.Reason
+}
@@ -1,3 +1,15 @@
 module github.com/renthraysk/stun
 
-go 1.15
+go 1.18
+
+require (
+	github.com/pion/dtls/v2 v2.2.12
+	github.com/pion/transport/v2 v2.2.4
+)
+
+require (
+	github.com/pion/logging v0.2.2 // indirect
+	golang.org/x/crypto v0.18.0 // indirect
+	golang.org/x/net v0.20.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+)
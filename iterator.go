@@ -0,0 +1,40 @@
+package stun
+
+// AttributeIterator iterates over the attributes of a raw STUN message without parsing or validating their values.
+// Values reference the raw message, and exclude padding.
+type AttributeIterator struct {
+	raw []byte
+	off int
+	err error
+}
+
+// Attributes returns an AttributeIterator over the attributes of raw. Header errors are reported by Err.
+func Attributes(raw []byte) AttributeIterator {
+	if err := validateHeader(raw); err != nil {
+		return AttributeIterator{err: err}
+	}
+	return AttributeIterator{raw: raw, off: headerSize}
+}
+
+// Next returns the type and value of the next attribute. ok is false once all attributes have been returned, or an
+// error is encountered.
+func (it *AttributeIterator) Next() (attrType Attr, attrValue []byte, ok bool) {
+	if it.err != nil {
+		return 0, nil, false
+	}
+	attrs := it.raw[it.off:]
+	if len(attrs) < 4 {
+		return 0, nil, false
+	}
+	attrType, attrSize := attributeType(attrs), attributeSize(attrs)
+	attrValue = attrs[4:]
+	if len(attrValue) < attrSize {
+		it.err = ErrUnexpectedEOF
+		return 0, nil, false
+	}
+	it.off += (attrSize + 7) & ^3
+	return attrType, attrValue[:attrSize:attrSize], true
+}
+
+// Err returns the first error encountered, if any.
+func (it *AttributeIterator) Err() error { return it.err }
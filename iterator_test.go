@@ -0,0 +1,68 @@
+package stun
+
+import (
+	"testing"
+)
+
+func TestAttributes(t *testing.T) {
+	b := New(TypeBindingRequest, txID)
+	b.SetSoftware("test")
+	b.SetPriority(110, 1, 1)
+	b.SetUsername("evtj:h6vY")
+	b.AddFingerprint()
+	raw, err := b.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	expected := []struct {
+		attrType Attr
+		size     int
+	}{
+		{attrType: AttrSoftware, size: 4},
+		{attrType: AttrPriority, size: 4},
+		{attrType: AttrUsername, size: 9},
+		{attrType: AttrFingerprint, size: 4},
+	}
+	it := Attributes(raw)
+	for i, e := range expected {
+		attrType, attrValue, ok := it.Next()
+		if !ok {
+			t.Fatalf("expected attribute %d, got none: %v", i, it.Err())
+		}
+		if attrType != e.attrType || len(attrValue) != e.size {
+			t.Fatalf("expected attribute %d to be %#04x of %d bytes, got %#04x of %d bytes", i, e.attrType, e.size, attrType, len(attrValue))
+		}
+	}
+	if _, _, ok := it.Next(); ok {
+		t.Fatal("expected no further attributes")
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := testing.AllocsPerRun(100, func() {
+		it := Attributes(raw)
+		for _, _, ok := it.Next(); ok; _, _, ok = it.Next() {
+		}
+	}); n != 0 {
+		t.Fatalf("expected no allocations, got %v", n)
+	}
+}
+
+func TestAttributesTruncated(t *testing.T) {
+	raw := newHeader(nil, TypeBindingRequest, txID)
+	raw = appendSoftware(raw, "test")
+	raw[headerSize+3] = 8 // claim SOFTWARE value is longer than the message
+	setAttrSize(raw)
+
+	it := Attributes(raw)
+	if _, _, ok := it.Next(); ok {
+		t.Fatal("expected no attribute")
+	}
+	if err := it.Err(); err != ErrUnexpectedEOF {
+		t.Fatalf("expected ErrUnexpectedEOF, got %v", err)
+	}
+	it = Attributes(raw[:headerSize-1])
+	if err := it.Err(); err != ErrNotASTUNMessage {
+		t.Fatalf("expected ErrNotASTUNMessage, got %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package stun
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+)
+
+// Message is the result of a successful Parse.
+// Attribute values are not copied, so slices returned from Message reference the buffer given to Parse, and are only
+// valid as long as that buffer is not reused.
+type Message struct {
+	typ  Type
+	txID TxID
+	raw  []byte
+
+	username           []byte
+	userHash           []byte
+	realm              []byte
+	nonce              []byte
+	software           []byte
+	alternateDomain    []byte
+	reason             []byte
+	unknownAttributes  []byte
+	passwordAlgorithms []byte
+
+	errorCode         ErrorCode
+	passwordAlgorithm PasswordAlgorithm
+	features          Features
+	priority          uint32
+	iceControlled     uint64
+	changeRequest     uint32
+	responsePort      uint16
+
+	mappedAddress    Address
+	xorMappedAddress Address
+	alternateServer  Address
+	responseOrigin   Address
+	otherAddress     Address
+
+	has attrSet
+
+	unknown    []Attr
+	unknownBuf [8]Attr
+
+	// key is the message integrity key validated against, kept to sign responses
+	key                          []byte
+	keyBuf                       [sha256.Size]byte
+	messageIntegritySHA256Length int
+}
+
+// attrSet records which of the attributes Message retains were present in the parsed message.
+type attrSet uint16
+
+const (
+	hasMappedAddress attrSet = 1 << iota
+	hasXorMappedAddress
+	hasAlternateServer
+	hasErrorCode
+	hasPriority
+	hasICEControlled
+	hasPasswordAlgorithm
+	hasMessageIntegrity
+	hasChangeRequest
+	hasResponsePort
+	hasPadding
+	hasResponseOrigin
+	hasOtherAddress
+)
+
+func (m *Message) Type() Type        { return m.typ }
+func (m *Message) TxID() (txID TxID) { copy(txID[:], m.txID[:]); return }
+func (m *Message) Reset()            { *m = Message{} }
+
+// MappedAddress returns the MAPPED-ADDRESS attribute, ok is false if not present.
+// See https://tools.ietf.org/html/rfc8489#section-14.1
+func (m *Message) MappedAddress() (ip net.IP, port uint16, ok bool) {
+	return m.mappedAddress.IP, m.mappedAddress.Port, m.has&hasMappedAddress != 0
+}
+
+// XorMappedAddress returns the XOR-MAPPED-ADDRESS attribute with the XOR already reversed, ok is false if not present.
+// See https://tools.ietf.org/html/rfc8489#section-14.2
+func (m *Message) XorMappedAddress() (ip net.IP, port uint16, ok bool) {
+	return m.xorMappedAddress.IP, m.xorMappedAddress.Port, m.has&hasXorMappedAddress != 0
+}
+
+// Username returns the USERNAME attribute value, or nil if not present.
+// See https://tools.ietf.org/html/rfc8489#section-14.3
+func (m *Message) Username() []byte { return m.username }
+
+// UserHash returns the USERHASH attribute value, or nil if not present.
+// See https://tools.ietf.org/html/rfc8489#section-14.4
+func (m *Message) UserHash() []byte { return m.userHash }
+
+// ErrorCode returns the ERROR-CODE attribute, ok is false if not present.
+// See https://tools.ietf.org/html/rfc8489#section-14.8
+func (m *Message) ErrorCode() (errorCode ErrorCode, reason []byte, ok bool) {
+	return m.errorCode, m.reason, m.has&hasErrorCode != 0
+}
+
+// UnknownAttributes returns the attribute types listed in the UNKNOWN-ATTRIBUTES attribute, or nil if not present.
+// See https://tools.ietf.org/html/rfc8489#section-14.13
+func (m *Message) UnknownAttributes() []Attr {
+	if len(m.unknownAttributes) == 0 {
+		return nil
+	}
+	attributes := make([]Attr, 0, len(m.unknownAttributes)/2)
+	for b := m.unknownAttributes; len(b) >= 2; b = b[2:] {
+		attributes = append(attributes, Attr(binary.BigEndian.Uint16(b)))
+	}
+	return attributes
+}
+
+// Realm returns the REALM attribute value, or nil if not present.
+// See https://tools.ietf.org/html/rfc8489#section-14.9
+func (m *Message) Realm() []byte { return m.realm }
+
+// Nonce returns the NONCE attribute value, or nil if not present.
+// See https://tools.ietf.org/html/rfc8489#section-14.10
+func (m *Message) Nonce() []byte { return m.nonce }
+
+// Features returns the security features encoded in the NONCE attribute, or 0 if the nonce did not start with the
+// security feature cookie.
+// See https://tools.ietf.org/html/rfc8489#section-9.2
+func (m *Message) Features() Features { return m.features }
+
+// Software returns the SOFTWARE attribute value, or nil if not present.
+// See https://tools.ietf.org/html/rfc8489#section-14.14
+func (m *Message) Software() []byte { return m.software }
+
+// AlternateServer returns the ALTERNATE-SERVER attribute, ok is false if not present.
+// See https://tools.ietf.org/html/rfc8489#section-14.15
+func (m *Message) AlternateServer() (ip net.IP, port uint16, ok bool) {
+	return m.alternateServer.IP, m.alternateServer.Port, m.has&hasAlternateServer != 0
+}
+
+// AlternateDomain returns the ALTERNATE-DOMAIN attribute value, or nil if not present.
+// See https://tools.ietf.org/html/rfc8489#section-14.16
+func (m *Message) AlternateDomain() []byte { return m.alternateDomain }
+
+// Priority returns the ICE PRIORITY attribute, ok is false if not present.
+// See https://tools.ietf.org/html/rfc8445#section-16.1
+func (m *Message) Priority() (priority uint32, ok bool) {
+	return m.priority, m.has&hasPriority != 0
+}
+
+// ICEControlled returns the ICE-CONTROLLED tie breaker, ok is false if not present.
+// See https://tools.ietf.org/html/rfc8445#section-16.1
+func (m *Message) ICEControlled() (tieBreaker uint64, ok bool) {
+	return m.iceControlled, m.has&hasICEControlled != 0
+}
+
+// ChangeRequest returns the flags of the CHANGE-REQUEST attribute, ok is false if not present.
+// See https://tools.ietf.org/html/rfc5780#section-7.2
+func (m *Message) ChangeRequest() (changeIP, changePort, ok bool) {
+	return m.changeRequest&changeIPFlag != 0, m.changeRequest&changePortFlag != 0, m.has&hasChangeRequest != 0
+}
+
+// ResponsePort returns the RESPONSE-PORT attribute, ok is false if not present.
+// See https://tools.ietf.org/html/rfc5780#section-7.5
+func (m *Message) ResponsePort() (port uint16, ok bool) {
+	return m.responsePort, m.has&hasResponsePort != 0
+}
+
+// Padding reports whether the message contained a PADDING attribute.
+// See https://tools.ietf.org/html/rfc5780#section-7.6
+func (m *Message) Padding() bool { return m.has&hasPadding != 0 }
+
+// ResponseOrigin returns the RESPONSE-ORIGIN attribute, ok is false if not present.
+// See https://tools.ietf.org/html/rfc5780#section-7.3
+func (m *Message) ResponseOrigin() (ip net.IP, port uint16, ok bool) {
+	return m.responseOrigin.IP, m.responseOrigin.Port, m.has&hasResponseOrigin != 0
+}
+
+// OtherAddress returns the OTHER-ADDRESS attribute, ok is false if not present.
+// See https://tools.ietf.org/html/rfc5780#section-7.4
+func (m *Message) OtherAddress() (ip net.IP, port uint16, ok bool) {
+	return m.otherAddress.IP, m.otherAddress.Port, m.has&hasOtherAddress != 0
+}
+
+// UnknownComprehensionRequired returns the comprehension-required attribute types present in the message that were
+// neither decoded by the Parser nor declared with Parser.SetComprehendedAttributes. A request with any should be
+// answered with a 420 error response, see Builder.SetUnknownAttributes.
+// See https://tools.ietf.org/html/rfc8489#section-6.3.1
+func (m *Message) UnknownComprehensionRequired() []Attr { return m.unknown }
+
+func (m *Message) addUnknownComprehensionRequired(a Attr) {
+	for _, u := range m.unknown {
+		if u == a {
+			return
+		}
+	}
+	if m.unknown == nil {
+		m.unknown = m.unknownBuf[:0]
+	}
+	m.unknown = append(m.unknown, a)
+}
+
+// Err returns an *ErrorResponse if the message is an error response, otherwise nil.
+// The ErrorResponse does not reference the buffer given to Parse.
+func (m *Message) Err() error {
+	if m.typ.Class() != ClassError {
+		return nil
+	}
+	if m.has&hasErrorCode == 0 {
+		return ErrMissingErrorCode
+	}
+	e := &ErrorResponse{
+		Code:              m.errorCode,
+		Reason:            string(m.reason),
+		UnknownAttributes: m.UnknownAttributes(),
+		AlternateDomain:   string(m.alternateDomain),
+		Realm:             string(m.realm),
+	}
+	if len(m.nonce) > 0 {
+		e.Nonce = append([]byte(nil), m.nonce...)
+	}
+	if ip, port, ok := m.AlternateServer(); ok {
+		e.AlternateServer = &net.UDPAddr{IP: append(net.IP(nil), ip...), Port: int(port)}
+	}
+	return e
+}
+
+// generateKey generates the message integrity key from the attributes parsed so far, if not already generated.
+func (m *Message) generateKey(k *keyGenerator) bool {
+	if m.key != nil {
+		return true
+	}
+	key, err := k.Generate(m.keyBuf[:0])
+	if err != nil {
+		return false
+	}
+	m.key = key
+	return true
+}
+
+// integrity reports whether the message carried a MESSAGE-INTEGRITY or MESSAGE-INTEGRITY-SHA256 attribute, which
+// Parse validated.
+func (m *Message) integrity() bool {
+	return m.has&hasMessageIntegrity != 0 || m.messageIntegritySHA256Length > 0
+}
+
+// signResponse adds the message integrity attributes present in the request m to the response b, using the same key.
+// See https://tools.ietf.org/html/rfc8489#section-9.1.4
+func (m *Message) signResponse(b *Builder) {
+	if m.key == nil || len(b.key) > 0 {
+		return
+	}
+	b.key = append(b.key[:0], m.key...)
+	if m.has&hasMessageIntegrity != 0 {
+		b.AddMessageIntegrity()
+	}
+	if m.messageIntegritySHA256Length > 0 {
+		b.AddMessageIntegritySHA256Truncated(m.messageIntegritySHA256Length)
+	}
+}
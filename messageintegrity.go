@@ -17,7 +17,7 @@ const (
 
 func appendFingerprint(m []byte) []byte {
 	binary.BigEndian.PutUint16(m[2:4], uint16(len(m)-headerSize+fingerprintSize))
-	return appendAttributeUint32(m, attrFingerprint, crc32.ChecksumIEEE(m)^fingerprintXor)
+	return appendAttributeUint32(m, AttrFingerprint, crc32.ChecksumIEEE(m)^fingerprintXor)
 }
 
 // validateFingerprint is called when fingerprint attribute is encountered.
@@ -65,22 +65,18 @@ func appendMessageIntegrity(m []byte, key []byte) []byte {
 	binary.BigEndian.PutUint16(m[2:4], uint16(len(m)-headerSize+4+sha1.Size))
 	mac := hmac.New(sha1.New, key)
 	mac.Write(m)
-	m = append(m, byte(attrMessageIntegrity>>8), byte(attrMessageIntegrity), byte(sha1.Size>>8), byte(sha1.Size))
+	m = append(m, byte(AttrMessageIntegrity>>8), byte(AttrMessageIntegrity), byte(sha1.Size>>8), byte(sha1.Size))
 	return mac.Sum(m)
 }
 
 // validateHMACSHA1 is called when MessageIntegritySHA256 attribute is encountered.
 // message slice spans the STUN message header plus all currently parsed attributes
 // attrValue slice spans the MessageIntegritySHA256 attribute
-func validateHMACSHA1(message, attrValue []byte, keygen keyGenerator) bool {
+func validateHMACSHA1(message, attrValue, key []byte) bool {
 	var b [sha1.Size]byte
 
-	key, err := keygen.Generate(b[:0])
-	if err != nil {
-		return false
-	}
-	binary.BigEndian.PutUint16(b[:2], uint16(len(message)-headerSize+4+sha1.Size))
 	mac := hmac.New(sha1.New, key)
+	binary.BigEndian.PutUint16(b[:2], uint16(len(message)-headerSize+4+sha1.Size))
 	mac.Write(message[:2]) // STUN message type
 	mac.Write(b[:2])       // patched STUN header attr length
 	mac.Write(message[4:]) // rest of STUN message until the MessageIntegrity attribute
@@ -101,7 +97,7 @@ func appendMessageIntegritySHA256(m []byte, key []byte, length int) []byte {
 	binary.BigEndian.PutUint16(m[2:4], uint16(len(m)-headerSize+4+length))
 	mac := hmac.New(sha256.New, key)
 	mac.Write(m)
-	m = append(m, byte(attrMessageIntegritySHA256>>8), byte(attrMessageIntegritySHA256), byte(length>>8), byte(length))
+	m = append(m, byte(AttrMessageIntegritySHA256>>8), byte(AttrMessageIntegritySHA256), byte(length>>8), byte(length))
 	m = mac.Sum(m)
 	return m[:len(m)-sha256.Size+length]
 }
@@ -109,19 +105,15 @@ func appendMessageIntegritySHA256(m []byte, key []byte, length int) []byte {
 // validateHMACSHA256 is called when MessageIntegritySHA256 attribute is encountered.
 // message slice spans the STUN message header plus all currently parsed attributes
 // attrValue slice spans the MessageIntegritySHA256 attribute value
-func validateHMACSHA256(message []byte, attrValue []byte, keygen keyGenerator) bool {
+func validateHMACSHA256(message, attrValue, key []byte) bool {
 	var b [sha256.Size]byte
 
 	length := len(attrValue)
 	if length > sha256.Size || length < 16 || length%4 != 0 {
 		return false
 	}
-	key, err := keygen.Generate(b[:0])
-	if err != nil {
-		return false
-	}
-	binary.BigEndian.PutUint16(b[:2], uint16(len(message)-headerSize+4+length))
 	mac := hmac.New(sha256.New, key)
+	binary.BigEndian.PutUint16(b[:2], uint16(len(message)-headerSize+4+length))
 	mac.Write(message[:2]) // STUN message type
 	mac.Write(b[:2])       // patched STUN header attr length
 	mac.Write(message[4:]) // rest of STUN message until the MessageIntegritySHA256 attribute
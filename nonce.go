@@ -0,0 +1,97 @@
+package stun
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// DefaultNonceLifetime is the lifetime of nonces issued by a NonceManager created with a zero lifetime.
+const DefaultNonceLifetime = 10 * time.Minute
+
+const (
+	nonceTimeSize = 8
+	nonceMACSize  = 16
+	// nonceSize is the length of a nonce issued by NonceManager, the cookie, the features and the base64 encoded
+	// timestamp and MAC.
+	nonceSize = len(nonceSecurityFeaturesPrefix) + 4 + (4*(nonceTimeSize+nonceMACSize)+2)/3
+)
+
+// NonceManager issues and validates nonces without keeping any per client state. Each nonce carries the time it was
+// issued and an HMAC over that time, the security features and the IP address of the client it was issued to, so
+// NonceManagers sharing a secret accept each other's nonces.
+// See https://tools.ietf.org/html/rfc8489#section-9.2
+type NonceManager struct {
+	secret   []byte
+	lifetime time.Duration
+}
+
+// NewNonceManager returns a NonceManager whose nonces are valid for lifetime, or DefaultNonceLifetime if zero.
+func NewNonceManager(secret []byte, lifetime time.Duration) *NonceManager {
+	if lifetime <= 0 {
+		lifetime = DefaultNonceLifetime
+	}
+	return &NonceManager{secret: append([]byte(nil), secret...), lifetime: lifetime}
+}
+
+// Nonce returns a new nonce for the client at addr, advertising the security features.
+func (n *NonceManager) Nonce(features Features, addr net.Addr) []byte {
+	return n.appendNonce(make([]byte, 0, nonceSize), features, addrIP(addr), time.Now())
+}
+
+// Validate checks the nonce was issued by a NonceManager sharing the secret to the client at addr. Returns
+// ErrInvalidNonce if not, or ErrStaleNonce if it has expired.
+func (n *NonceManager) Validate(nonce []byte, addr net.Addr) error {
+	return n.validate(nonce, addrIP(addr), time.Now())
+}
+
+func (n *NonceManager) appendNonce(b []byte, features Features, ip net.IP, now time.Time) []byte {
+	var buf [nonceTimeSize + sha256.Size]byte
+
+	b = appendSecurityFeatures(b, features)
+	binary.BigEndian.PutUint64(buf[:nonceTimeSize], uint64(now.Unix()))
+	n.mac(buf[nonceTimeSize:nonceTimeSize], b[len(b)-4:], buf[:nonceTimeSize], ip)
+	var enc [nonceSize - len(nonceSecurityFeaturesPrefix) - 4]byte
+	base64.RawStdEncoding.Encode(enc[:], buf[:nonceTimeSize+nonceMACSize])
+	return append(b, enc[:]...)
+}
+
+func (n *NonceManager) validate(nonce []byte, ip net.IP, now time.Time) error {
+	var buf [nonceTimeSize + nonceMACSize]byte
+	var mac [sha256.Size]byte
+
+	const i = len(nonceSecurityFeaturesPrefix) + 4
+	if len(nonce) != nonceSize || string(nonce[:len(nonceSecurityFeaturesPrefix)]) != nonceSecurityFeaturesPrefix {
+		return ErrInvalidNonce
+	}
+	if _, err := base64.RawStdEncoding.Decode(buf[:], nonce[i:]); err != nil {
+		return ErrInvalidNonce
+	}
+	if !hmac.Equal(buf[nonceTimeSize:], n.mac(mac[:0], nonce[i-4:i], buf[:nonceTimeSize], ip)[:nonceMACSize]) {
+		return ErrInvalidNonce
+	}
+	age := now.Sub(time.Unix(int64(binary.BigEndian.Uint64(buf[:nonceTimeSize])), 0))
+	if age < -n.lifetime || age >= n.lifetime {
+		return ErrStaleNonce
+	}
+	return nil
+}
+
+func (n *NonceManager) mac(b []byte, features []byte, issued []byte, ip net.IP) []byte {
+	h := hmac.New(sha256.New, n.secret)
+	h.Write(features)
+	h.Write(issued)
+	h.Write(ip.To16())
+	return h.Sum(b)
+}
+
+// addrIP returns the IP address of addr, or nil if not an IP transport address.
+func addrIP(addr net.Addr) net.IP {
+	if a, ok := udpAddr(addr); ok {
+		return a.IP
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package stun
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNonceManager(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 32853}
+	n := NewNonceManager([]byte("secret"), time.Minute)
+
+	nonce := n.Nonce(FeaturePasswordAlgorithms, addr)
+	if len(nonce) != nonceSize {
+		t.Fatalf("expected nonce length %d, got %d", nonceSize, len(nonce))
+	}
+	if len(nonce) > maxNonceByteLength {
+		t.Fatalf("nonce exceeds %d bytes", maxNonceByteLength)
+	}
+	if err := n.Validate(nonce, addr); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	// Port changes, as with a new TCP connection, are accepted
+	if err := n.Validate(nonce, &net.TCPAddr{IP: addr.IP, Port: 1}); err != nil {
+		t.Fatalf("validate with different port failed: %v", err)
+	}
+	if err := NewNonceManager([]byte("secret"), time.Minute).Validate(nonce, addr); err != nil {
+		t.Fatalf("validate with shared secret failed: %v", err)
+	}
+	if err := NewNonceManager([]byte("other"), time.Minute).Validate(nonce, addr); err != ErrInvalidNonce {
+		t.Fatalf("expected ErrInvalidNonce with different secret, got %v", err)
+	}
+	if err := n.Validate(nonce, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: addr.Port}); err != ErrInvalidNonce {
+		t.Fatalf("expected ErrInvalidNonce with different address, got %v", err)
+	}
+
+	// Tampering with the security features invalidates the nonce
+	tampered := append([]byte(nil), nonce...)
+	copy(tampered[len(nonceSecurityFeaturesPrefix):], "AAAA")
+	if err := n.Validate(tampered, addr); err != ErrInvalidNonce {
+		t.Fatalf("expected ErrInvalidNonce for tampered features, got %v", err)
+	}
+	if err := n.Validate([]byte("nonce"), addr); err != ErrInvalidNonce {
+		t.Fatalf("expected ErrInvalidNonce, got %v", err)
+	}
+
+	var m Message
+	var p Parser
+	b := New(TypeBindingError, txID)
+	b.SetErrorCode(ErrorCodeUnauthenticated, "Unauthenticated")
+	b.SetNonce(nonce)
+	raw, err := b.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if m.Features() != FeaturePasswordAlgorithms {
+		t.Fatalf("expected FeaturePasswordAlgorithms, got %v", m.Features())
+	}
+}
+
+func TestNonceManagerExpiry(t *testing.T) {
+	ip := net.IPv4(192, 0, 2, 1)
+	n := NewNonceManager([]byte("secret"), time.Minute)
+	now := time.Now()
+
+	nonce := n.appendNonce(nil, 0, ip, now)
+	if err := n.validate(nonce, ip, now.Add(59*time.Second)); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+	if err := n.validate(nonce, ip, now.Add(time.Minute)); err != ErrStaleNonce {
+		t.Fatalf("expected ErrStaleNonce, got %v", err)
+	}
+}
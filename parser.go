@@ -6,75 +6,47 @@ import (
 	"encoding/binary"
 )
 
-func attributeType(a []byte) attr { return attr(binary.BigEndian.Uint16(a[:2])) }
+func attributeType(a []byte) Attr { return Attr(binary.BigEndian.Uint16(a[:2])) }
 func attributeSize(a []byte) int  { return int(uint(binary.BigEndian.Uint16(a[2:4]))) }
 
-type keyGenerator struct {
-	password          string
-	username          []byte
-	realm             []byte
-	userHash          []byte
-	passwordAlgorithm PasswordAlgorithm
-}
-
-func (k *keyGenerator) GetPassword() ([]byte, error) {
-	return []byte{15: 0}, nil
+type Parser struct {
+	key         []byte
+	credentials CredentialStore
+	comprehend  []Attr
 }
 
-func (k *keyGenerator) GetPasswordByUserHash(userHash []byte) ([]byte, error) {
-	return []byte{15: 0}, nil
+func NewParser() (*Parser, error) {
+	return &Parser{}, nil
 }
 
-func (k *keyGenerator) GetPasswordUsernameRealm(username, realm []byte) ([]byte, error) {
-	return []byte{15: 0}, nil
+// SetPassword sets the short term key used to validate MessageIntegrity and MessageIntegritySHA256 attributes.
+// When set, the key is used for every message regardless of any CredentialStore.
+func (p *Parser) SetPassword(password string) {
+	p.key = append(p.key[:0], password...)
 }
 
-func (k *keyGenerator) Generate(b []byte) ([]byte, error) {
-	var (
-		key []byte
-		err error
-	)
-
-	if len(k.userHash) != 0 {
-		key, err = k.GetPasswordByUserHash(k.userHash)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		if len(k.realm) == 0 {
-			return []byte(k.password), nil
-		}
-		if len(k.username) == 0 {
-			return nil, ErrMissingUsername
-		}
-		key, err = k.GetPasswordUsernameRealm(k.username, k.realm)
-		if err != nil {
-			return nil, err
-		}
-	}
-	switch k.passwordAlgorithm {
+// SetKeyLongTerm sets the long term key used to validate MessageIntegrity and MessageIntegritySHA256 attributes.
+// When set, the key is used for every message regardless of any CredentialStore.
+func (p *Parser) SetKeyLongTerm(passwordAlgorithm PasswordAlgorithm, username, realm, password string) error {
+	switch passwordAlgorithm {
 	case PasswordAlgorithmMD5:
-		return appendLongTermKeyMD5(b, k.username, k.realm, key), nil
+		p.key = appendLongTermKeyMD5String(p.key[:0], username, realm, password)
 	case PasswordAlgorithmSHA256:
-		return appendLongTermKeySHA256(b, k.username, k.realm, key), nil
+		p.key = appendLongTermKeySHA256String(p.key[:0], username, realm, password)
 	default:
-		return nil, ErrUnknownPasswordAlgorithm
+		return ErrUnknownPasswordAlgorithm
 	}
+	return nil
 }
 
-type Parser struct {
-	key []byte
-}
-
-func NewParser() (*Parser, error) {
-	return &Parser{}, nil
-}
-
-func (p *Parser) SetPassword(password string) {
-	p.key = append(p.key[:0], password...)
+// SetCredentialStore sets the CredentialStore consulted to validate MessageIntegrity and MessageIntegritySHA256
+// attributes, using the USERNAME, USERHASH and REALM attributes of each message.
+func (p *Parser) SetCredentialStore(credentials CredentialStore) {
+	p.credentials = credentials
 }
 
-func (p *Parser) Parse(dst *Message, in []byte) error {
+// validateHeader checks in is plausibly a STUN message, and its header length field matches its length.
+func validateHeader(in []byte) error {
 	if len(in) < headerSize {
 		return ErrNotASTUNMessage
 	}
@@ -90,11 +62,39 @@ func (p *Parser) Parse(dst *Message, in []byte) error {
 	if binary.BigEndian.Uint32(in[4:8]) != magicCookie {
 		return ErrNotASTUNMessage
 	}
+	return nil
+}
+
+// SetComprehendedAttributes declares comprehension-required attributes the caller understands in addition to those
+// Parse decodes itself. Any other comprehension-required attribute is reported by
+// Message.UnknownComprehensionRequired.
+// See https://tools.ietf.org/html/rfc8489#section-15
+func (p *Parser) SetComprehendedAttributes(attributes ...Attr) {
+	p.comprehend = append(p.comprehend[:0], attributes...)
+}
+
+func (p *Parser) comprehends(a Attr) bool {
+	for _, c := range p.comprehend {
+		if c == a {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Parser) Parse(dst *Message, in []byte) error {
+	if err := validateHeader(in); err != nil {
+		return err
+	}
 
-	keyGen := keyGenerator{passwordAlgorithm: PasswordAlgorithmMD5}
+	dst.Reset()
+	dst.typ = Type(binary.BigEndian.Uint16(in[:2]))
+	copy(dst.txID[:], in[8:])
+	keyGen := keyGenerator{key: p.key, credentials: p.credentials, passwordAlgorithm: PasswordAlgorithmMD5}
 
 	bytesParsed := headerSize
-	for attrs := in[headerSize:]; len(attrs) > 4; attrs = in[bytesParsed:] {
+	// An attribute header alone is a complete attribute, with an empty value
+	for attrs := in[headerSize:]; len(attrs) >= 4; attrs = in[bytesParsed:] {
 		attrType, attrSize := attributeType(attrs), attributeSize(attrs)
 		attrValue := attrs[4:]
 		if len(attrValue) < attrSize {
@@ -102,43 +102,150 @@ func (p *Parser) Parse(dst *Message, in []byte) error {
 		}
 		switch attrType {
 
-		case attrUsername:
+		case AttrMappedAddress:
+			if err := dst.mappedAddress.Unmarshal(in, attrType, attrValue[:attrSize]); err != nil {
+				return err
+			}
+			dst.has |= hasMappedAddress
+
+		case AttrXorMappedAddress:
+			if err := dst.xorMappedAddress.Unmarshal(in, attrType, attrValue[:attrSize]); err != nil {
+				return err
+			}
+			dst.has |= hasXorMappedAddress
+
+		case AttrAlternateServer:
+			if err := dst.alternateServer.Unmarshal(in, attrType, attrValue[:attrSize]); err != nil {
+				return err
+			}
+			dst.has |= hasAlternateServer
+
+		case AttrResponseOrigin:
+			if err := dst.responseOrigin.Unmarshal(in, attrType, attrValue[:attrSize]); err != nil {
+				return err
+			}
+			dst.has |= hasResponseOrigin
+
+		case AttrOtherAddress:
+			if err := dst.otherAddress.Unmarshal(in, attrType, attrValue[:attrSize]); err != nil {
+				return err
+			}
+			dst.has |= hasOtherAddress
+
+		case AttrChangeRequest:
+			if attrSize != 4 {
+				return ErrMalformedAttribute
+			}
+			dst.changeRequest = binary.BigEndian.Uint32(attrValue)
+			dst.has |= hasChangeRequest
+
+		case AttrResponsePort:
+			if attrSize != 4 {
+				return ErrMalformedAttribute
+			}
+			dst.responsePort = binary.BigEndian.Uint16(attrValue)
+			dst.has |= hasResponsePort
+
+		case AttrPadding:
+			dst.has |= hasPadding
+
+		case AttrUsername:
 			if attrSize > maxUsernameByteLength {
 				return ErrUsernameTooLong
 			}
 			keyGen.username = attrValue[:attrSize]
+			dst.username = keyGen.username
 
-		case attrRealm:
+		case AttrRealm:
 			if attrSize > maxRealmByteLength {
 				return ErrRealmTooLong
 			}
 			keyGen.realm = attrValue[:attrSize]
+			dst.realm = keyGen.realm
+
+		case AttrSoftware:
+			if attrSize > maxSoftwareByteLength {
+				return ErrSoftwareTooLong
+			}
+			dst.software = attrValue[:attrSize]
+
+		case AttrAlternateDomain:
+			if attrSize > maxAlternateDomainByteLength {
+				return ErrDomainTooLong
+			}
+			dst.alternateDomain = attrValue[:attrSize]
 
-		case attrNonce:
+		case AttrErrorCode:
+			if attrSize < 4 {
+				return ErrUnexpectedEOF
+			}
+			if attrSize-4 > maxReasonByteLength {
+				return ErrReasonTooLong
+			}
+			errorCode, ok := errorCodeFromAttribute(attrValue)
+			if !ok {
+				return ErrInvalidErrorCode
+			}
+			dst.errorCode = errorCode
+			dst.reason = attrValue[4:attrSize]
+			dst.has |= hasErrorCode
+
+		case AttrPriority:
+			if attrSize != 4 {
+				return ErrMalformedAttribute
+			}
+			dst.priority = binary.BigEndian.Uint32(attrValue)
+			dst.has |= hasPriority
+
+		case AttrICEControlled:
+			if attrSize != 8 {
+				return ErrMalformedAttribute
+			}
+			dst.iceControlled = binary.BigEndian.Uint64(attrValue)
+			dst.has |= hasICEControlled
+
+		case AttrNonce:
+			if attrSize > maxNonceByteLength {
+				return ErrNonceTooLong
+			}
+			dst.nonce = attrValue[:attrSize]
 			if attrSize < len(nonceSecurityFeaturesPrefix)+4 ||
 				string(attrValue[:len(nonceSecurityFeaturesPrefix)]) != nonceSecurityFeaturesPrefix {
 				break
 			}
-			// @TODO base64 decode security feature bits?
+			f, ok := decodeSecurityFeatures(attrValue[len(nonceSecurityFeaturesPrefix):])
+			if !ok {
+				return ErrMalformedAttribute
+			}
+			dst.features = f
 
-		case attrPasswordAlgorithm:
+		case AttrPasswordAlgorithm:
 			if attrSize < 4 {
 				return ErrUnexpectedEOF
 			}
 			switch a := PasswordAlgorithm(binary.BigEndian.Uint16(attrValue[:2])); a {
 			case PasswordAlgorithmMD5, PasswordAlgorithmSHA256:
 				keyGen.passwordAlgorithm = a
+				dst.passwordAlgorithm = a
+				dst.has |= hasPasswordAlgorithm
 			default:
 				return ErrUnknownPasswordAlgorithm
 			}
 
-		case attrUserHash:
+		case AttrPasswordAlgorithms:
+			if !validPasswordAlgorithms(attrValue[:attrSize]) {
+				return ErrMalformedAttribute
+			}
+			dst.passwordAlgorithms = attrValue[:attrSize]
+
+		case AttrUserHash:
 			if attrSize != sha256.Size {
 				return ErrInvalidUserHash
 			}
 			keyGen.userHash = attrValue[:sha256.Size]
+			dst.userHash = keyGen.userHash
 
-		case attrMessageIntegrity:
+		case AttrMessageIntegrity:
 			if attrSize != sha1.Size {
 				return ErrMessageIntegrity
 			}
@@ -149,11 +256,11 @@ func (p *Parser) Parse(dst *Message, in []byte) error {
 					return ErrUnexpectedEOF
 				}
 				switch attributeType(a) {
-				case attrFingerprint:
+				case AttrFingerprint:
 					// ignore everything after messageintegrity and fingerprint attributes
 					in = in[:bytesParsed+4+sha1.Size+fingerprintSize]
 
-				case attrMessageIntegritySHA256:
+				case AttrMessageIntegritySHA256:
 					n := 4 + attributeSize(a)
 					if len(a) < n {
 						return ErrUnexpectedEOF
@@ -162,7 +269,7 @@ func (p *Parser) Parse(dst *Message, in []byte) error {
 						if len(a) < fingerprintSize {
 							return ErrUnexpectedEOF
 						}
-						if attributeType(a) != attrFingerprint {
+						if attributeType(a) != AttrFingerprint {
 							return ErrInvalidAttributeSequence
 						}
 						n += fingerprintSize
@@ -176,29 +283,31 @@ func (p *Parser) Parse(dst *Message, in []byte) error {
 				attrValue = attrValue[:sha1.Size]
 			}
 
-			if !validateHMACSHA1(in[:bytesParsed], attrValue, keyGen) {
+			if !dst.generateKey(&keyGen) || !validateHMACSHA1(in[:bytesParsed], attrValue, dst.key) {
 				return ErrMessageIntegrity
 			}
+			dst.has |= hasMessageIntegrity
 
-		case attrMessageIntegritySHA256:
+		case AttrMessageIntegritySHA256:
 			// The value will be at most 32 bytes, but it MUST be at least 16 bytes and MUST be a multiple of 4 bytes.
 			if attrSize > sha256.Size || attrSize < 16 || attrSize%4 != 0 {
 				return ErrMessageIntegritySHA256
 			}
 			if len(attrValue) > attrSize {
 				// Only fingerprint attribute is allowed to follow messageintegritysha256 attribute
-				if a := attrValue[attrSize:]; len(a) < fingerprintSize || attributeType(a) != attrFingerprint {
+				if a := attrValue[attrSize:]; len(a) < fingerprintSize || attributeType(a) != AttrFingerprint {
 					return ErrInvalidAttributeSequence
 				}
 				// ignore everything after messageintegritysha256 and fingerprint attributes
 				in = in[:bytesParsed+4+attrSize+fingerprintSize]
 				attrValue = attrValue[:attrSize]
 			}
-			if !validateHMACSHA256(in[:bytesParsed], attrValue, keyGen) {
+			if !dst.generateKey(&keyGen) || !validateHMACSHA256(in[:bytesParsed], attrValue, dst.key) {
 				return ErrMessageIntegritySHA256
 			}
+			dst.messageIntegritySHA256Length = attrSize
 
-		case attrFingerprint:
+		case AttrFingerprint:
 			if attrSize != 4 {
 				return ErrFingerprint
 			}
@@ -209,12 +318,22 @@ func (p *Parser) Parse(dst *Message, in []byte) error {
 			if !validateFingerprint(in[:bytesParsed], binary.BigEndian.Uint32(attrValue)) {
 				return ErrFingerprint
 			}
+
+		case AttrUnknownAttributes:
+			if attrSize%2 != 0 {
+				return ErrMalformedAttribute
+			}
+			dst.unknownAttributes = attrValue[:attrSize]
+
+		default:
+			if attrType.ComprehensionRequired() && !p.comprehends(attrType) {
+				dst.addUnknownComprehensionRequired(attrType)
+			}
 		}
 		bytesParsed += (attrSize + 7) & ^3
 	}
 
-	dst.typ = Type(binary.BigEndian.Uint16(in[:2]))
-	copy(dst.txID[:], in[8:])
+	dst.raw = in
 
 	return nil
 }
@@ -3,6 +3,8 @@ package stun
 import (
 	"crypto/sha256"
 	"encoding/binary"
+	"errors"
+	"net"
 	"testing"
 )
 
@@ -49,6 +51,7 @@ func TestParseMessageIntegrity(t *testing.T) {
 		t.Fatalf("build failed: %v", err)
 	}
 	var p Parser
+	p.SetPassword(testPassword)
 	var m Message
 
 	if err := p.Parse(&m, raw); err != nil {
@@ -75,6 +78,7 @@ func TestParseMessageIntegritySHA256(t *testing.T) {
 		t.Fatalf("build failed: %v", err)
 	}
 	var p Parser
+	p.SetPassword(testPassword)
 	var m Message
 	if err := p.Parse(&m, raw); err != nil {
 		t.Fatalf("failed: %v", err)
@@ -101,6 +105,7 @@ func TestParseMessageIntegritySHA256Fingerprint(t *testing.T) {
 		t.Fatalf("build failed: %v", err)
 	}
 	var p Parser
+	p.SetPassword(testPassword)
 	var m Message
 
 	if err := p.Parse(&m, raw); err != nil {
@@ -126,6 +131,7 @@ func TestParseMessageIntegrityFingerprintIsAllowed(t *testing.T) {
 	setAttrSize(raw)
 
 	var p Parser
+	p.SetPassword(testPassword)
 	var m Message
 
 	if err := p.Parse(&m, raw); err != nil {
@@ -139,6 +145,7 @@ func TestParseMessageIntegrityFollowedByMessageIntegrity256IsAllowed(t *testing.
 	raw = appendMessageIntegritySHA256(raw, testKey, sha256.Size)
 	setAttrSize(raw)
 	var p Parser
+	p.SetPassword(testPassword)
 	var m Message
 	if err := p.Parse(&m, raw); err != nil {
 		t.Fatalf("allowed attribute sequence failed: %v", err)
@@ -152,6 +159,7 @@ func TestParseMessageIntegrityShouldBeOnlyFollowedByFingerprint(t *testing.T) {
 	raw = appendSoftware(raw, "test")
 	setAttrSize(raw)
 	var p Parser
+	p.SetPassword(testPassword)
 	var m Message
 	if err := p.Parse(&m, raw); err != ErrInvalidAttributeSequence {
 		t.Fatal("invalid attribute sequence did not cause expected invalid attribute sequence error")
@@ -165,6 +173,7 @@ func TestParseMessageIntegritySHA256ShouldBeOnlyFollowedByFingerprint(t *testing
 	raw = appendSoftware(raw, "test")
 	setAttrSize(raw)
 	var p Parser
+	p.SetPassword(testPassword)
 	var m Message
 	if err := p.Parse(&m, raw); err != ErrInvalidAttributeSequence {
 		t.Fatal("invalid attribute sequence did not cause expected invalid attribute sequence error")
@@ -183,3 +192,338 @@ func TestParseFingerprintShouldBeLastAttribute(t *testing.T) {
 		t.Fatalf("invalid attribute sequence did not cause expected invalid attribute sequence error")
 	}
 }
+
+func TestParseAttributes(t *testing.T) {
+	const (
+		software = "test"
+		username = "user"
+		realm    = "example.org"
+		nonce    = "f//499k954d6OL34oL9FSTvy64sA"
+		reason   = "Unauthenticated"
+	)
+	b := New(TypeBindingRequest, txID)
+	b.SetSoftware(software)
+	b.SetUsername(username)
+	b.SetRealm(realm)
+	b.SetNonce([]byte(nonce))
+	raw, err := b.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	var p Parser
+	var m Message
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if s := string(m.Software()); s != software {
+		t.Errorf("expected software %q, got %q", software, s)
+	}
+	if s := string(m.Username()); s != username {
+		t.Errorf("expected username %q, got %q", username, s)
+	}
+	if s := string(m.Realm()); s != realm {
+		t.Errorf("expected realm %q, got %q", realm, s)
+	}
+	if s := string(m.Nonce()); s != nonce {
+		t.Errorf("expected nonce %q, got %q", nonce, s)
+	}
+	if m.UserHash() != nil {
+		t.Error("unexpected userhash")
+	}
+
+	b = New(TypeBindingError, txID)
+	b.SetErrorCode(ErrorCodeUnauthenticated, reason)
+	raw, err = b.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if ec, r, ok := m.ErrorCode(); !ok || ec != ErrorCodeUnauthenticated || string(r) != reason {
+		t.Errorf("expected error code %d %q, got %d %q", ErrorCodeUnauthenticated, reason, ec, r)
+	}
+
+	b = New(TypeBindingSuccess, txID)
+	b.SetXorMappingAddress(&net.UDPAddr{IP: net.IP{192, 0, 2, 1}, Port: 32853})
+	b.SetMappingAddress(&net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 3478})
+	raw, err = b.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if ip, port, ok := m.XorMappedAddress(); !ok || !ip.Equal(net.IP{192, 0, 2, 1}) || port != 32853 {
+		t.Errorf("unexpected xor mapped address %v:%d", ip, port)
+	}
+	if ip, port, ok := m.MappedAddress(); !ok || !ip.Equal(net.ParseIP("2001:db8::1")) || port != 3478 {
+		t.Errorf("unexpected mapped address %v:%d", ip, port)
+	}
+	if _, _, ok := m.AlternateServer(); ok {
+		t.Error("unexpected alternate server")
+	}
+}
+
+func TestParseInvalidErrorCode(t *testing.T) {
+	raw := newHeader(nil, TypeBindingSuccess, txID)
+	raw = appendAttribute(raw, AttrErrorCode, []byte{0, 0, 2, 0})
+	setAttrSize(raw)
+	var p Parser
+	var m Message
+	if err := p.Parse(&m, raw); err != ErrInvalidErrorCode {
+		t.Fatalf("expected ErrInvalidErrorCode, got %v", err)
+	}
+}
+
+// testCredentials is a CredentialStore holding a single user
+type testCredentials struct {
+	username string
+	realm    string
+	password string
+}
+
+func (c testCredentials) Password(username []byte) ([]byte, error) {
+	if string(username) != c.username {
+		return nil, ErrMessageIntegrity
+	}
+	return []byte(c.password), nil
+}
+
+func (c testCredentials) LongTermPassword(username, realm []byte) ([]byte, error) {
+	if string(username) != c.username || string(realm) != c.realm {
+		return nil, ErrMessageIntegrity
+	}
+	return []byte(c.password), nil
+}
+
+func (c testCredentials) LongTermPasswordByUserHash(userHash, realm []byte) ([]byte, []byte, error) {
+	h := appendUserHash(nil, c.username, c.realm)
+	if string(userHash) != string(h[4:]) || string(realm) != c.realm {
+		return nil, nil, ErrMessageIntegrity
+	}
+	return []byte(c.username), []byte(c.password), nil
+}
+
+func TestParseCredentialStoreShortTerm(t *testing.T) {
+	b := New(TypeBindingRequest, txID)
+	b.SetUsername("user")
+	b.SetPassword(testPassword)
+	b.AddMessageIntegrity()
+	b.AddMessageIntegritySHA256()
+	raw, err := b.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	var p Parser
+	var m Message
+	p.SetCredentialStore(testCredentials{username: "user", password: testPassword})
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	p.SetCredentialStore(testCredentials{username: "other", password: testPassword})
+	if err := p.Parse(&m, raw); err != ErrMessageIntegrity {
+		t.Fatalf("expected ErrMessageIntegrity for unknown user, got %v", err)
+	}
+	p.SetCredentialStore(nil)
+	if err := p.Parse(&m, raw); err != ErrMessageIntegrity {
+		t.Fatalf("expected ErrMessageIntegrity without credentials, got %v", err)
+	}
+}
+
+func TestParseManyUnknownComprehensionRequired(t *testing.T) {
+	raw := newHeader(nil, TypeBindingRequest, txID)
+	for a := Attr(0x0040); a < 0x0054; a++ {
+		raw = appendAttribute(raw, a, nil)
+	}
+	setAttrSize(raw)
+
+	var p Parser
+	var m Message
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	u := m.UnknownComprehensionRequired()
+	if len(u) != 20 {
+		t.Fatalf("expected 20 unknown attributes, got %d", len(u))
+	}
+	for i, a := range u {
+		if a != Attr(0x0040+i) {
+			t.Fatalf("expected unknown attribute %v at %d, got %v", Attr(0x0040+i), i, a)
+		}
+	}
+}
+
+func TestParseZeroLengthFinalAttribute(t *testing.T) {
+	raw := newHeader(nil, TypeBindingRequest, txID)
+	raw = appendAttribute(raw, AttrUseCandidate, nil)
+	setAttrSize(raw)
+
+	var p Parser
+	var m Message
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if u := m.UnknownComprehensionRequired(); len(u) != 1 || u[0] != AttrUseCandidate {
+		t.Fatalf("expected USE-CANDIDATE unknown, got %v", u)
+	}
+}
+
+func TestParseUnknownComprehensionRequired(t *testing.T) {
+	raw := newHeader(nil, TypeBindingRequest, txID)
+	raw = appendAttribute(raw, AttrUseCandidate, nil)
+	raw = appendAttributeUint32(raw, AttrChannelNumber, 1234)
+	raw = appendAttribute(raw, AttrUseCandidate, nil)
+	raw = appendICEControlled(raw, 1)
+	raw = appendAttribute(raw, AttrICEControlling, []byte{7: 0})
+	setAttrSize(raw)
+
+	var p Parser
+	var m Message
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if u := m.UnknownComprehensionRequired(); len(u) != 2 || u[0] != AttrUseCandidate || u[1] != AttrChannelNumber {
+		t.Fatalf("expected USE-CANDIDATE and CHANNEL-NUMBER unknown, got %v", u)
+	}
+
+	p.SetComprehendedAttributes(AttrUseCandidate)
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if u := m.UnknownComprehensionRequired(); len(u) != 1 || u[0] != AttrChannelNumber {
+		t.Fatalf("expected CHANNEL-NUMBER unknown, got %v", u)
+	}
+
+	b := New(TypeBindingError, txID)
+	b.SetUnknownAttributes("Unknown Attribute", m.UnknownComprehensionRequired()...)
+	if _, err := b.Build(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+}
+
+func TestMessageErr(t *testing.T) {
+	b := New(TypeBindingError, txID)
+	b.SetErrorCode(ErrorCodeTryAlternate, "Try Alternate")
+	b.SetAlternateServer(net.IP{192, 0, 2, 1}, 3478)
+	b.SetAlternateDomain("stun.example.org")
+	b.SetRealm("example.org")
+	b.SetNonce([]byte("nonce"))
+	raw, err := b.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	var p Parser
+	var m Message
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	var e *ErrorResponse
+	if !errors.As(m.Err(), &e) {
+		t.Fatalf("expected *ErrorResponse, got %v", m.Err())
+	}
+	// Error response must not reference raw
+	for i := range raw {
+		raw[i] = 0
+	}
+	if e.Code != ErrorCodeTryAlternate || e.Reason != "Try Alternate" {
+		t.Errorf("unexpected error code %d %q", e.Code, e.Reason)
+	}
+	if e.AlternateServer == nil || !e.AlternateServer.IP.Equal(net.IP{192, 0, 2, 1}) || e.AlternateServer.Port != 3478 {
+		t.Errorf("unexpected alternate server %v", e.AlternateServer)
+	}
+	if e.AlternateDomain != "stun.example.org" || e.Realm != "example.org" || string(e.Nonce) != "nonce" {
+		t.Errorf("unexpected alternate domain %q, realm %q or nonce %q", e.AlternateDomain, e.Realm, e.Nonce)
+	}
+	if e.Error() != "error response 300 Try Alternate" {
+		t.Errorf("unexpected error string %q", e.Error())
+	}
+}
+
+func TestMessageErrUnknownAttributes(t *testing.T) {
+	b := New(TypeBindingError, txID)
+	b.SetUnknownAttributes("Unknown Attribute", AttrUseCandidate, AttrResponsePort, AttrPadding)
+	raw, err := b.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	var p Parser
+	var m Message
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	var e *ErrorResponse
+	if !errors.As(m.Err(), &e) {
+		t.Fatalf("expected *ErrorResponse, got %v", m.Err())
+	}
+	if e.Code != ErrorCodeUnknownAttribute {
+		t.Errorf("expected error code %d, got %d", ErrorCodeUnknownAttribute, e.Code)
+	}
+	if u := e.UnknownAttributes; len(u) != 3 || u[0] != AttrUseCandidate || u[1] != AttrResponsePort || u[2] != AttrPadding {
+		t.Errorf("unexpected unknown attributes %v", u)
+	}
+}
+
+func TestMessageErrSuccess(t *testing.T) {
+	b := New(TypeBindingSuccess, txID)
+	raw, err := b.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	var p Parser
+	var m Message
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if err := m.Err(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestParseNonceSecurityFeatures(t *testing.T) {
+	tests := []Features{0, FeaturePasswordAlgorithms, FeatureUserAnonyminity, FeaturePasswordAlgorithms | FeatureUserAnonyminity, 0xFFFFFF}
+
+	var p Parser
+	var m Message
+	for _, f := range tests {
+		b := New(TypeBindingError, txID)
+		b.SetErrorCode(ErrorCodeUnauthenticated, "Unauthenticated")
+		b.SetNonceWithSecurityFeatures(f, []byte("nonce"))
+		raw, err := b.Build()
+		if err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		if err := p.Parse(&m, raw); err != nil {
+			t.Fatalf("parse failed: %v", err)
+		}
+		if m.Features() != f {
+			t.Fatalf("expected features %x, got %x", f, m.Features())
+		}
+	}
+
+	raw := newHeader(nil, TypeBindingError, txID)
+	raw = appendNonce(raw, []byte(nonceSecurityFeaturesPrefix+"AA*Anonce"))
+	setAttrSize(raw)
+	if err := p.Parse(&m, raw); err != ErrMalformedAttribute {
+		t.Fatalf("expected ErrMalformedAttribute, got %v", err)
+	}
+}
+
+func TestSelectPasswordAlgorithmStripped(t *testing.T) {
+	// Nonce advertises PASSWORD-ALGORITHMS, but attribute has been removed
+	b := New(TypeBindingError, txID)
+	b.SetErrorCode(ErrorCodeUnauthenticated, "Unauthenticated")
+	b.SetNonceWithSecurityFeatures(FeaturePasswordAlgorithms, []byte("nonce"))
+	raw, err := b.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	var p Parser
+	var m Message
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if _, err := m.SelectPasswordAlgorithm(); err != ErrMissingPasswordAlgorithms {
+		t.Fatalf("expected ErrMissingPasswordAlgorithms, got %v", err)
+	}
+}
@@ -0,0 +1,72 @@
+package stun
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// PasswordAlgorithm returns the PASSWORD-ALGORITHM attribute, ok is false if not present.
+// See https://tools.ietf.org/html/rfc8489#section-14.12
+func (m *Message) PasswordAlgorithm() (passwordAlgorithm PasswordAlgorithm, ok bool) {
+	return m.passwordAlgorithm, m.has&hasPasswordAlgorithm != 0
+}
+
+// PasswordAlgorithms returns the algorithms listed in the PASSWORD-ALGORITHMS attribute in order of preference, or
+// nil if not present.
+// See https://tools.ietf.org/html/rfc8489#section-14.11
+func (m *Message) PasswordAlgorithms() []PasswordAlgorithm {
+	var passwordAlgorithms []PasswordAlgorithm
+
+	for b := m.passwordAlgorithms; len(b) >= 4; b = b[4+(attributeSize(b)+3)&^3:] {
+		passwordAlgorithms = append(passwordAlgorithms, PasswordAlgorithm(binary.BigEndian.Uint16(b)))
+	}
+	return passwordAlgorithms
+}
+
+// SelectPasswordAlgorithm is used by clients on receipt of a 401 response to pick the first algorithm offered in
+// PASSWORD-ALGORITHMS that is supported. Returns PasswordAlgorithmMD5 if the server did not offer any, unless the
+// nonce advertises FeaturePasswordAlgorithms in which case the attribute has been stripped.
+// See https://tools.ietf.org/html/rfc8489#section-9.2.5
+func (m *Message) SelectPasswordAlgorithm() (PasswordAlgorithm, error) {
+	if len(m.passwordAlgorithms) == 0 {
+		if m.features&FeaturePasswordAlgorithms != 0 {
+			return 0, ErrMissingPasswordAlgorithms
+		}
+		return PasswordAlgorithmMD5, nil
+	}
+	for _, a := range m.PasswordAlgorithms() {
+		switch a {
+		case PasswordAlgorithmMD5, PasswordAlgorithmSHA256:
+			return a, nil
+		}
+	}
+	return 0, ErrUnknownPasswordAlgorithm
+}
+
+// CheckPasswordAlgorithms is used by servers to protect against bid-down attacks on authenticated requests. The
+// PASSWORD-ALGORITHMS in the request must match exactly those the server advertised, and PASSWORD-ALGORITHM must be
+// one of them. A request with neither is treated as using PasswordAlgorithmMD5. Failures should be answered with a
+// 400 error response.
+// See https://tools.ietf.org/html/rfc8489#section-9.2.4
+func (m *Message) CheckPasswordAlgorithms(advertised ...PasswordAlgorithm) error {
+	if len(m.passwordAlgorithms) == 0 {
+		if m.has&hasPasswordAlgorithm == 0 {
+			return nil
+		}
+		return ErrMissingPasswordAlgorithms
+	}
+	if m.has&hasPasswordAlgorithm == 0 {
+		return ErrMissingPasswordAlgorithm
+	}
+	var buf [64]byte
+
+	if !bytes.Equal(m.passwordAlgorithms, appendPasswordAlgorithms(buf[:0], advertised)[4:]) {
+		return ErrPasswordAlgorithmsMismatch
+	}
+	for _, a := range advertised {
+		if a == m.passwordAlgorithm {
+			return nil
+		}
+	}
+	return ErrPasswordAlgorithmNotOffered
+}
@@ -0,0 +1,118 @@
+package stun
+
+import (
+	"testing"
+)
+
+func TestPasswordAlgorithms(t *testing.T) {
+	const (
+		username = "user"
+		realm    = "example.org"
+	)
+	advertised := []PasswordAlgorithm{PasswordAlgorithmSHA256, PasswordAlgorithmMD5}
+
+	// Server 401 response advertising supported algorithms
+	b := New(TypeBindingError, txID)
+	b.SetErrorCode(ErrorCodeUnauthenticated, "Unauthenticated")
+	b.SetRealm(realm)
+	b.SetNonceWithSecurityFeatures(FeaturePasswordAlgorithms, []byte("nonce"))
+	b.SetPasswordAlgorithms(advertised...)
+	raw, err := b.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	var p Parser
+	var m Message
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	offered := m.PasswordAlgorithms()
+	if len(offered) != 2 || offered[0] != PasswordAlgorithmSHA256 || offered[1] != PasswordAlgorithmMD5 {
+		t.Fatalf("unexpected password algorithms %v", offered)
+	}
+	a, err := m.SelectPasswordAlgorithm()
+	if err != nil || a != PasswordAlgorithmSHA256 {
+		t.Fatalf("expected SHA256 to be selected, got %v: %v", a, err)
+	}
+
+	tests := []struct {
+		name   string
+		echoed []PasswordAlgorithm
+		algo   PasswordAlgorithm
+		err    error
+	}{
+		{name: "echoed", echoed: offered, algo: PasswordAlgorithmSHA256, err: nil},
+		{name: "echoed md5", echoed: offered, algo: PasswordAlgorithmMD5, err: nil},
+		{name: "bid down", echoed: []PasswordAlgorithm{PasswordAlgorithmMD5}, algo: PasswordAlgorithmMD5, err: ErrPasswordAlgorithmsMismatch},
+		{name: "reordered", echoed: []PasswordAlgorithm{PasswordAlgorithmMD5, PasswordAlgorithmSHA256}, algo: PasswordAlgorithmMD5, err: ErrPasswordAlgorithmsMismatch},
+	}
+	for _, tt := range tests {
+		b := New(TypeBindingRequest, txID)
+		b.SetUsername(username)
+		b.SetRealm(realm)
+		b.SetPasswordAlgorithms(tt.echoed...)
+		b.SetKeyLongTerm(tt.algo, username, realm, testPassword)
+		b.AddMessageIntegritySHA256()
+		raw, err := b.Build()
+		if err != nil {
+			t.Fatalf("%s: build failed: %v", tt.name, err)
+		}
+		var p Parser
+		p.SetCredentialStore(testCredentials{username: username, realm: realm, password: testPassword})
+		if err := p.Parse(&m, raw); err != nil {
+			t.Fatalf("%s: parse failed: %v", tt.name, err)
+		}
+		if a, ok := m.PasswordAlgorithm(); !ok || a != tt.algo {
+			t.Fatalf("%s: expected password algorithm %v, got %v", tt.name, tt.algo, a)
+		}
+		if err := m.CheckPasswordAlgorithms(advertised...); err != tt.err {
+			t.Fatalf("%s: expected error %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestCheckPasswordAlgorithmsMissing(t *testing.T) {
+	var p Parser
+	var m Message
+
+	// Neither attribute, treated as MD5
+	raw := newHeader(nil, TypeBindingRequest, txID)
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if err := m.CheckPasswordAlgorithms(PasswordAlgorithmSHA256); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// PASSWORD-ALGORITHM without PASSWORD-ALGORITHMS
+	raw = appendPasswordAlgorithm(raw, PasswordAlgorithmSHA256, nil)
+	setAttrSize(raw)
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if err := m.CheckPasswordAlgorithms(PasswordAlgorithmSHA256); err != ErrMissingPasswordAlgorithms {
+		t.Fatalf("expected ErrMissingPasswordAlgorithms, got %v", err)
+	}
+
+	// PASSWORD-ALGORITHMS without PASSWORD-ALGORITHM
+	raw = newHeader(nil, TypeBindingRequest, txID)
+	raw = appendPasswordAlgorithms(raw, []PasswordAlgorithm{PasswordAlgorithmSHA256})
+	setAttrSize(raw)
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if err := m.CheckPasswordAlgorithms(PasswordAlgorithmSHA256); err != ErrMissingPasswordAlgorithm {
+		t.Fatalf("expected ErrMissingPasswordAlgorithm, got %v", err)
+	}
+}
+
+func TestParseMalformedPasswordAlgorithms(t *testing.T) {
+	raw := newHeader(nil, TypeBindingRequest, txID)
+	raw = appendAttribute(raw, AttrPasswordAlgorithms, []byte{0, 1, 0, 8, 0, 0, 0, 0})
+	setAttrSize(raw)
+	var p Parser
+	var m Message
+	if err := p.Parse(&m, raw); err != ErrMalformedAttribute {
+		t.Fatalf("expected ErrMalformedAttribute, got %v", err)
+	}
+}
@@ -76,4 +76,14 @@ func TestRFC8489TestVectorB1_Errata(t *testing.T) {
 	if !bytes.Equal(raw, expected) {
 		t.Fatal("build generate different output")
 	}
+	var p Parser
+	var m Message
+
+	p.SetCredentialStore(testCredentials{username: username, realm: realm, password: password})
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if f := m.Features(); f != FeatureUserAnonyminity {
+		t.Fatalf("expected user anonymity feature, got %v", f)
+	}
 }
@@ -74,6 +74,7 @@ func TestRFC5769SampleRequest2_1(t *testing.T) {
 	var p Parser
 	var m Message
 
+	p.SetPassword(password)
 	if err := p.Parse(&m, raw); err != nil {
 		t.Fatalf("failed to unmarshal message: %v", err)
 	}
@@ -134,4 +135,11 @@ func TestRFC5769Sample2_4(t *testing.T) {
 	if !bytes.Equal(expected, raw) {
 		t.Fatal("build generated different output")
 	}
+	var p Parser
+	var m Message
+
+	p.SetCredentialStore(testCredentials{username: username, realm: realm, password: password})
+	if err := p.Parse(&m, raw); err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
 }
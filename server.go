@@ -0,0 +1,409 @@
+package stun
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxMessageSize is the largest STUN message the Server and Client will read.
+const maxMessageSize = 4 * 1024
+
+// aLongTimeAgo is a deadline in the past, used to unblock reads.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// transientError reports whether err, returned reading from a socket, leaves the socket usable, so the read may be
+// retried. Timeouts are not transient, as they are how reads are interrupted.
+func transientError(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && !ne.Timeout() && !errors.Is(err, net.ErrClosed)
+}
+
+// Request is a STUN request or indication received by a Server.
+// Message references the buffer the request was read into, so must not be retained once ServeSTUN returns.
+type Request struct {
+	Message    *Message
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+
+	// behavior is the NAT behavior discovery server socket the request was received on, if any
+	behavior *behaviorTransport
+}
+
+// NewResponse returns a Builder for a success response to the request.
+func (r *Request) NewResponse() *Builder {
+	return New(NewType(r.Message.Type().Method(), ClassSuccess), r.Message.TxID())
+}
+
+// NewErrorResponse returns a Builder for an error response to the request.
+func (r *Request) NewErrorResponse(errorCode ErrorCode, reason string) *Builder {
+	b := New(NewType(r.Message.Type().Method(), ClassError), r.Message.TxID())
+	b.SetErrorCode(errorCode, reason)
+	return b
+}
+
+// Handler responds to a STUN request by returning the Builder of the response, or nil for no response.
+// Responses to indications are never sent.
+type Handler interface {
+	ServeSTUN(r *Request) *Builder
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(r *Request) *Builder
+
+func (f HandlerFunc) ServeSTUN(r *Request) *Builder { return f(r) }
+
+// BindingHandler answers Binding requests with the transport address the request was received from.
+// See https://tools.ietf.org/html/rfc8489#section-3
+var BindingHandler Handler = HandlerFunc(func(r *Request) *Builder {
+	if r.Message.Type().Class() != ClassRequest {
+		return nil
+	}
+	addr, ok := udpAddr(r.RemoteAddr)
+	if !ok {
+		return r.NewErrorResponse(ErrorCodeServerErrorRetry, "Server Error")
+	}
+	b := r.NewResponse()
+	b.SetXorMappingAddress(addr)
+	return b
+})
+
+// udpAddr converts transport addresses to the *net.UDPAddr expected by Builder.
+func udpAddr(addr net.Addr) (*net.UDPAddr, bool) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a, true
+	case *net.TCPAddr:
+		return &net.UDPAddr{IP: a.IP, Port: a.Port, Zone: a.Zone}, true
+	case *TLSAddr:
+		return &net.UDPAddr{IP: a.IP, Port: a.Port, Zone: a.Zone}, true
+	case *DTLSAddr:
+		return &a.UDPAddr, true
+	}
+	return nil, false
+}
+
+// Server responds to STUN requests, dispatching them by method to the registered Handler. Binding requests are
+// answered by BindingHandler unless another Handler is registered for MethodBinding.
+type Server struct {
+	// ComprehendedAttributes lists comprehension-required attributes understood by the handlers, in addition to
+	// those decoded by Parser. Requests containing any other are answered with a 420 error response.
+	ComprehendedAttributes []Attr
+	// ErrorLog receives errors reading, parsing and answering requests. If nil errors are discarded.
+	ErrorLog *log.Logger
+	// Credentials, if set, requires requests to be authenticated. Responses are signed with the same message
+	// integrity attributes and key as the request.
+	// See https://tools.ietf.org/html/rfc8489#section-9
+	Credentials CredentialStore
+	// Realm selects the long term credential mechanism if not empty, otherwise the short term credential mechanism is
+	// used.
+	Realm string
+	// PasswordAlgorithms are advertised to clients using the long term credential mechanism, in order of preference.
+	// Defaults to PasswordAlgorithmSHA256 then PasswordAlgorithmMD5.
+	PasswordAlgorithms []PasswordAlgorithm
+	// Nonces issues and validates the nonces of the long term credential mechanism. Servers sharing a NonceManager
+	// secret accept each other's nonces. If nil a NonceManager with a random secret is created on first use.
+	Nonces *NonceManager
+
+	mu           sync.Mutex
+	randomNonces *NonceManager
+	handlers     map[Method]Handler
+	// conns maps the connections and listeners being served to the function interrupting them on Shutdown
+	conns  map[interface{}]func()
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Handle registers the Handler for requests and indications of method.
+func (s *Server) Handle(method Method, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = make(map[Method]Handler)
+	}
+	s.handlers[method] = handler
+}
+
+func (s *Server) handler(method Method) Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h, ok := s.handlers[method]; ok {
+		return h
+	}
+	if method == MethodBinding {
+		return BindingHandler
+	}
+	return nil
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	}
+}
+
+// trackConn adds or removes conn from the set of connections and listeners being served, returning false if the
+// server has been shutdown. interrupt is called on Shutdown to unblock reads or accepts.
+func (s *Server) trackConn(conn interface{}, interrupt func(), add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.conns, conn)
+		s.wg.Done()
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[interface{}]func())
+	}
+	s.conns[conn] = interrupt
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Serve answers requests received on pc until ctx is done or Shutdown is called, returning ctx.Err() or
+// ErrServerClosed respectively. pc is not closed by Serve.
+func (s *Server) Serve(ctx context.Context, pc net.PacketConn) error {
+	return s.ServeTransport(ctx, NewPacketTransport(pc))
+}
+
+// ServeTransport answers requests received on t until ctx is done or Shutdown is called, returning ctx.Err() or
+// ErrServerClosed respectively. Responses are sent on t to the transport address the request was received from.
+func (s *Server) ServeTransport(ctx context.Context, t Transport) error {
+	if !s.trackConn(t, func() { t.SetReadDeadline(aLongTimeAgo) }, true) {
+		return ErrServerClosed
+	}
+	defer s.trackConn(t, nil, false)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			t.SetReadDeadline(aLongTimeAgo)
+		case <-stop:
+		}
+	}()
+	return s.serveTransport(ctx, t)
+}
+
+// ServeListener accepts connections from l, answering requests framed on each stream until ctx is done or Shutdown
+// is called, returning ctx.Err() or ErrServerClosed respectively. l and all accepted connections are closed when
+// ServeListener returns.
+// See https://tools.ietf.org/html/rfc8489#section-6.2.2
+func (s *Server) ServeListener(ctx context.Context, l net.Listener) error {
+	interrupt := func(conn net.Conn) { conn.SetReadDeadline(aLongTimeAgo) }
+	return s.serveListener(ctx, l, interrupt, func(ctx context.Context, conn net.Conn) {
+		s.serveConn(ctx, conn, NewStreamTransport(conn))
+	})
+}
+
+// serveListener accepts connections from l, calling serve with each in its own goroutine. interrupt is called to
+// unblock reads on a connection once ctx is done or on Shutdown.
+func (s *Server) serveListener(ctx context.Context, l net.Listener, interrupt func(conn net.Conn), serve func(ctx context.Context, conn net.Conn)) error {
+	if !s.trackConn(l, func() { l.Close() }, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.trackConn(l, nil, false)
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if transientError(err) {
+				s.logf("stun: accept error: %v", err)
+				continue
+			}
+			return err
+		}
+		if !s.trackConn(conn, func() { interrupt(conn) }, true) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.trackConn(conn, nil, false)
+			stop := make(chan struct{})
+			defer close(stop)
+			go func() {
+				select {
+				case <-ctx.Done():
+					interrupt(conn)
+				case <-stop:
+				}
+			}()
+			serve(ctx, conn)
+		}()
+	}
+}
+
+// serveConn answers requests received on t, a Transport over conn, until reading fails, then closes conn.
+func (s *Server) serveConn(ctx context.Context, conn net.Conn, t Transport) {
+	defer conn.Close()
+	err := s.serveTransport(ctx, t)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return
+	}
+	if err != io.EOF && err != ErrServerClosed && err != ctx.Err() {
+		s.logf("stun: connection from %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// serveTransport answers requests received on t until reading fails, or writing to a reliable transport fails.
+// Transient errors reading from unreliable transports are logged and ignored.
+func (s *Server) serveTransport(ctx context.Context, t Transport) error {
+	var m Message
+
+	p := s.newParser()
+	for {
+		in, from, local, err := t.ReadMessage()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if transientError(err) && !t.Reliable() {
+				s.logf("stun: read error: %v", err)
+				continue
+			}
+			return err
+		}
+		r := Request{Message: &m, RemoteAddr: from, LocalAddr: local}
+		if bt, ok := t.(*behaviorTransport); ok {
+			r.behavior = bt
+		}
+		raw, out, to := s.serve(p, &r, in)
+		if raw == nil {
+			continue
+		}
+		if out == nil {
+			out, to = t, from
+		}
+		if err := out.WriteMessage(raw, to); err != nil {
+			if t.Reliable() {
+				return err
+			}
+			s.logf("stun: write to %s: %v", to, err)
+		}
+	}
+}
+
+func (s *Server) newParser() *Parser {
+	var p Parser
+
+	p.SetComprehendedAttributes(s.ComprehendedAttributes...)
+	p.SetCredentialStore(s.Credentials)
+	return &p
+}
+
+// serve parses the request and returns the raw response, or nil if none should be sent. The response is sent on out
+// to to, or if out is nil on the transport the request was received on to its source.
+func (s *Server) serve(p *Parser, r *Request, in []byte) (raw []byte, out Transport, to net.Addr) {
+	err := p.Parse(r.Message, in)
+	if err != nil && (s.Credentials == nil || (err != ErrMessageIntegrity && err != ErrMessageIntegritySHA256)) {
+		s.logf("stun: parse from %s: %v", r.RemoteAddr, err)
+		return nil, nil, nil
+	}
+	class := r.Message.Type().Class()
+	if class != ClassRequest && class != ClassIndication {
+		return nil, nil, nil
+	}
+	var b *Builder
+	if s.Credentials != nil {
+		b = s.authenticate(r, err)
+	}
+	if b == nil {
+		if u := s.unknownAttributes(r); len(u) > 0 {
+			b = New(NewType(r.Message.Type().Method(), ClassError), r.Message.TxID())
+			b.SetUnknownAttributes("Unknown Attribute", u...)
+		} else if h := s.handler(r.Message.Type().Method()); h != nil {
+			if r.behavior != nil {
+				b, out, to = r.behavior.serve(r, h)
+			} else {
+				b = h.ServeSTUN(r)
+			}
+		} else {
+			b = r.NewErrorResponse(ErrorCodeBadRequest, "Bad Request")
+		}
+		if b != nil {
+			r.Message.signResponse(b)
+		}
+	}
+	if b == nil || class != ClassRequest {
+		return nil, nil, nil
+	}
+	if raw, err = b.Build(); err != nil {
+		s.logf("stun: response to %s: %v", r.RemoteAddr, err)
+		return nil, nil, nil
+	}
+	return raw, out, to
+}
+
+// unknownAttributes returns the comprehension-required attributes of the request the server does not understand,
+// including the NAT behavior discovery attributes unless received by ServeBehaviorDiscovery.
+func (s *Server) unknownAttributes(r *Request) []Attr {
+	u := r.Message.UnknownComprehensionRequired()
+	if r.behavior != nil || r.Message.has&(hasChangeRequest|hasResponsePort|hasPadding) == 0 {
+		return u
+	}
+	u = append([]Attr(nil), u...)
+	if r.Message.has&hasChangeRequest != 0 {
+		u = append(u, AttrChangeRequest)
+	}
+	if r.Message.has&hasResponsePort != 0 {
+		u = append(u, AttrResponsePort)
+	}
+	if r.Message.has&hasPadding != 0 {
+		u = append(u, AttrPadding)
+	}
+	return u
+}
+
+// Shutdown stops all Serve calls, waiting for them to return or ctx to be done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	for _, interrupt := range s.conns {
+		interrupt()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
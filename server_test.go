@@ -0,0 +1,392 @@
+package stun
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveTest runs serve, which serves s at addr, returning addr and a function that shuts s down and checks serve
+// returned ErrServerClosed.
+func serveTest(t *testing.T, s *Server, addr net.Addr, serve func(ctx context.Context) error) (net.Addr, func()) {
+	t.Helper()
+	errCh := make(chan error, 1)
+	go func() { errCh <- serve(context.Background()) }()
+	return addr, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			t.Errorf("shutdown failed: %v", err)
+		}
+		if err := <-errCh; err != ErrServerClosed {
+			t.Errorf("expected ErrServerClosed, got %v", err)
+		}
+	}
+}
+
+// serveUDPTest runs s on a loopback UDP socket, returning the server address and a function to shut it down.
+func serveUDPTest(t *testing.T, s *Server) (net.Addr, func()) {
+	t.Helper()
+	pc := listenTest(t)
+	addr, shutdown := serveTest(t, s, pc.LocalAddr(), func(ctx context.Context) error { return s.Serve(ctx, pc) })
+	return addr, func() {
+		shutdown()
+		pc.Close()
+	}
+}
+
+// roundTrip sends raw to addr and parses the response into m.
+func roundTrip(t *testing.T, p *Parser, m *Message, addr net.Addr, raw []byte) *net.UDPConn {
+	t.Helper()
+	conn, err := net.DialUDP("udp", nil, addr.(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	if _, err := conn.Write(raw); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, maxMessageSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if err := p.Parse(m, buf[:n]); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	return conn
+}
+
+func TestServerBinding(t *testing.T) {
+	var s Server
+	addr, shutdown := serveUDPTest(t, &s)
+	defer shutdown()
+
+	raw, err := New(TypeBindingRequest, TxID{1}).Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	var p Parser
+	var m Message
+	conn := roundTrip(t, &p, &m, addr, raw)
+	defer conn.Close()
+
+	if m.Type() != TypeBindingSuccess || m.TxID() != (TxID{1}) {
+		t.Fatalf("unexpected response %v", m.Type())
+	}
+	local := conn.LocalAddr().(*net.UDPAddr)
+	if ip, port, ok := m.XorMappedAddress(); !ok || !ip.Equal(local.IP) || int(port) != local.Port {
+		t.Fatalf("expected xor mapped address %v, got %v:%d", local, ip, port)
+	}
+}
+
+func TestServe(t *testing.T) {
+	pc := listenTest(t)
+	done := make(chan struct{})
+	go func() {
+		Serve(pc, "")
+		close(done)
+	}()
+
+	raw, err := New(TypeBindingRequest, TxID{1}).Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	var p Parser
+	var m Message
+	conn := roundTrip(t, &p, &m, pc.LocalAddr(), raw)
+	defer conn.Close()
+	if m.Type() != TypeBindingSuccess || m.TxID() != (TxID{1}) {
+		t.Fatalf("unexpected response %v", m.Type())
+	}
+
+	pc.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected Serve to return once closed")
+	}
+}
+
+func TestServerHandle(t *testing.T) {
+	const method Method = 0x123
+
+	var s Server
+	s.Handle(method, HandlerFunc(func(r *Request) *Builder {
+		b := r.NewResponse()
+		b.SetSoftware("custom")
+		return b
+	}))
+	addr, shutdown := serveUDPTest(t, &s)
+	defer shutdown()
+
+	var p Parser
+	var m Message
+
+	raw, err := New(NewType(method, ClassRequest), TxID{2}).Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	roundTrip(t, &p, &m, addr, raw).Close()
+	if m.Type() != NewType(method, ClassSuccess) || string(m.Software()) != "custom" {
+		t.Fatalf("unexpected response %v", m.Type())
+	}
+
+	raw, err = New(NewType(0x124, ClassRequest), TxID{3}).Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	roundTrip(t, &p, &m, addr, raw).Close()
+	if ec, _, ok := m.ErrorCode(); !ok || ec != ErrorCodeBadRequest {
+		t.Fatalf("expected 400 for unknown method, got %v", m.Type())
+	}
+}
+
+func TestServerUnknownAttributes(t *testing.T) {
+	var s Server
+	addr, shutdown := serveUDPTest(t, &s)
+	defer shutdown()
+
+	raw := newHeader(nil, TypeBindingRequest, TxID{4})
+	raw = appendAttribute(raw, AttrUseCandidate, nil)
+	setAttrSize(raw)
+
+	var p Parser
+	var m Message
+	roundTrip(t, &p, &m, addr, raw).Close()
+	if ec, _, ok := m.ErrorCode(); !ok || ec != ErrorCodeUnknownAttribute {
+		t.Fatalf("expected 420 response, got %v", m.Type())
+	}
+	if u := m.UnknownAttributes(); len(u) != 1 || u[0] != AttrUseCandidate {
+		t.Fatalf("expected USE-CANDIDATE to be unknown, got %v", u)
+	}
+}
+
+func TestServerServeContext(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer pc.Close()
+
+	var s Server
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.Serve(ctx, pc) }()
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after context cancelled")
+	}
+}
+
+func TestServerShortTermCredentials(t *testing.T) {
+	s := Server{Credentials: testCredentials{username: "user", password: testPassword}}
+	addr, shutdown := serveUDPTest(t, &s)
+	defer shutdown()
+
+	var p Parser
+	var m Message
+
+	raw, err := New(TypeBindingRequest, TxID{5}).Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	roundTrip(t, &p, &m, addr, raw).Close()
+	if ec, _, ok := m.ErrorCode(); !ok || ec != ErrorCodeBadRequest {
+		t.Fatalf("expected 400 without message integrity, got %v", m.Type())
+	}
+
+	b := New(TypeBindingRequest, TxID{6})
+	b.SetUsername("user")
+	b.SetPassword("wrong")
+	b.AddMessageIntegrity()
+	if raw, err = b.Build(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	roundTrip(t, &p, &m, addr, raw).Close()
+	if ec, _, ok := m.ErrorCode(); !ok || ec != ErrorCodeUnauthenticated {
+		t.Fatalf("expected 401 for wrong password, got %v", m.Type())
+	}
+
+	b = New(TypeBindingRequest, TxID{7})
+	b.SetUsername("user")
+	b.SetPassword(testPassword)
+	b.AddMessageIntegrity()
+	b.AddMessageIntegritySHA256()
+	if raw, err = b.Build(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	p.SetPassword(testPassword)
+	roundTrip(t, &p, &m, addr, raw).Close()
+	if m.Type() != TypeBindingSuccess {
+		t.Fatalf("expected success, got %v", m.Type())
+	}
+	if m.has&hasMessageIntegrity == 0 || m.messageIntegritySHA256Length != 32 {
+		t.Fatal("expected response signed with MESSAGE-INTEGRITY and MESSAGE-INTEGRITY-SHA256")
+	}
+}
+
+func TestServerLongTermCredentials(t *testing.T) {
+	const (
+		username = "user"
+		realm    = "example.org"
+	)
+	s := Server{
+		Credentials: testCredentials{username: username, realm: realm, password: testPassword},
+		Realm:       realm,
+	}
+	addr, shutdown := serveUDPTest(t, &s)
+	defer shutdown()
+
+	var p Parser
+	var m Message
+
+	raw, err := New(TypeBindingRequest, TxID{8}).Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	roundTrip(t, &p, &m, addr, raw).Close()
+	if ec, _, ok := m.ErrorCode(); !ok || ec != ErrorCodeUnauthenticated {
+		t.Fatalf("expected 401, got %v", m.Type())
+	}
+	if string(m.Realm()) != realm {
+		t.Fatalf("expected realm %q, got %q", realm, m.Realm())
+	}
+	if f := m.Features(); f&FeaturePasswordAlgorithms == 0 {
+		t.Fatalf("expected nonce to advertise password algorithms, got %v", f)
+	}
+	nonce := append([]byte(nil), m.Nonce()...)
+	alg, err := m.SelectPasswordAlgorithm()
+	if err != nil || alg != PasswordAlgorithmSHA256 {
+		t.Fatalf("expected SHA256 password algorithm, got %v (%v)", alg, err)
+	}
+	algs := m.PasswordAlgorithms()
+
+	request := func(txID TxID, nonce []byte, algs []PasswordAlgorithm) []byte {
+		b := New(TypeBindingRequest, txID)
+		b.SetUsername(username)
+		b.SetRealm(realm)
+		b.SetNonce(nonce)
+		b.SetPasswordAlgorithms(algs...)
+		b.SetKeyLongTerm(alg, username, realm, testPassword)
+		b.AddMessageIntegritySHA256()
+		raw, err := b.Build()
+		if err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		return raw
+	}
+
+	if err := p.SetKeyLongTerm(alg, username, realm, testPassword); err != nil {
+		t.Fatalf("set key failed: %v", err)
+	}
+	roundTrip(t, &p, &m, addr, request(TxID{9}, nonce, algs)).Close()
+	if m.Type() != TypeBindingSuccess {
+		t.Fatalf("expected success, got %v", m.Type())
+	}
+	if m.messageIntegritySHA256Length != 32 {
+		t.Fatal("expected response signed with MESSAGE-INTEGRITY-SHA256")
+	}
+
+	conn := roundTrip(t, &p, &m, addr, request(TxID{10}, []byte("invalid"), algs))
+	conn.Close()
+	if ec, _, ok := m.ErrorCode(); !ok || ec != ErrorCodeUnauthenticated {
+		t.Fatalf("expected 401 for invalid nonce, got %v", m.Type())
+	}
+	nonces, _ := s.nonces()
+	if err := nonces.Validate(m.Nonce(), conn.LocalAddr()); err != nil {
+		t.Fatalf("expected fresh nonce with 401, got %v", err)
+	}
+
+	forged := append([]byte(nil), nonce...)
+	forged[len(forged)-1] ^= 1
+	roundTrip(t, &p, &m, addr, request(TxID{10}, forged, algs)).Close()
+	if ec, _, ok := m.ErrorCode(); !ok || ec != ErrorCodeUnauthenticated {
+		t.Fatalf("expected 401 for forged nonce, got %v", m.Type())
+	}
+
+	// Issued earlier so a fresh nonce would differ
+	nonce = nonces.appendNonce(nil, nonceFeatures, conn.LocalAddr().(*net.UDPAddr).IP, time.Now().Add(-10*time.Second))
+	b := New(TypeBindingRequest, TxID{10})
+	b.SetUsername(username)
+	b.SetRealm(realm)
+	b.SetNonce(nonce)
+	b.SetPasswordAlgorithms(algs...)
+	b.SetKeyLongTerm(alg, username, realm, "wrong")
+	b.AddMessageIntegritySHA256()
+	if raw, err = b.Build(); err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	roundTrip(t, &p, &m, addr, raw).Close()
+	if ec, _, ok := m.ErrorCode(); !ok || ec != ErrorCodeUnauthenticated {
+		t.Fatalf("expected 401 for wrong password, got %v", m.Type())
+	}
+	if string(m.Nonce()) != string(nonce) {
+		t.Fatal("expected 401 for wrong password to return the request's nonce")
+	}
+
+	roundTrip(t, &p, &m, addr, request(TxID{11}, nonce, []PasswordAlgorithm{PasswordAlgorithmSHA256})).Close()
+	if ec, _, ok := m.ErrorCode(); !ok || ec != ErrorCodeBadRequest {
+		t.Fatalf("expected 400 for mismatched password algorithms, got %v", m.Type())
+	}
+}
+
+func TestServerStaleNonce(t *testing.T) {
+	const (
+		username = "user"
+		realm    = "example.org"
+	)
+	s := Server{
+		Credentials: testCredentials{username: username, realm: realm, password: testPassword},
+		Realm:       realm,
+		Nonces:      NewNonceManager([]byte("secret"), time.Minute),
+	}
+	addr, shutdown := serveUDPTest(t, &s)
+	defer shutdown()
+
+	conn, err := net.DialUDP("udp", nil, addr.(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	local := conn.LocalAddr().(*net.UDPAddr)
+	nonce := s.Nonces.appendNonce(nil, nonceFeatures, local.IP, time.Now().Add(-2*time.Minute))
+
+	b := New(TypeBindingRequest, TxID{12})
+	b.SetUsername(username)
+	b.SetRealm(realm)
+	b.SetNonce(nonce)
+	b.SetKeyLongTerm(PasswordAlgorithmMD5, username, realm, testPassword)
+	b.AddMessageIntegrity()
+	raw, err := b.Build()
+	if err != nil {
+		t.Fatalf("build failed: %v", err)
+	}
+	if _, err := conn.Write(raw); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, maxMessageSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	var p Parser
+	var m Message
+	if err := p.Parse(&m, buf[:n]); err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if ec, _, ok := m.ErrorCode(); !ok || ec != ErrorCodeStaleNonce {
+		t.Fatalf("expected 438, got %v", m.Type())
+	}
+	if err := s.Nonces.Validate(m.Nonce(), local); err != nil {
+		t.Fatalf("expected fresh nonce with 438, got %v", err)
+	}
+}
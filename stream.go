@@ -0,0 +1,164 @@
+package stun
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// StreamReader reads STUN messages from a byte stream such as TCP or TLS, framing each by the length field of its
+// header.
+// See https://tools.ietf.org/html/rfc8489#section-6.2.2
+type StreamReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+// NewStreamReader returns a StreamReader reading from r.
+func NewStreamReader(r io.Reader) *StreamReader {
+	return &StreamReader{r: r, buf: make([]byte, maxMessageSize)}
+}
+
+// ReadMessage returns the next message in the stream. The message references a buffer reused by the next call.
+// ErrNotASTUNMessage is returned if the stream does not contain a STUN header where one is expected, after which
+// framing is lost so the stream should be closed.
+func (s *StreamReader) ReadMessage() ([]byte, error) {
+	if _, err := io.ReadFull(s.r, s.buf[:headerSize]); err != nil {
+		return nil, err
+	}
+	if s.buf[0] > 0x3F || binary.BigEndian.Uint32(s.buf[4:8]) != magicCookie {
+		return nil, ErrNotASTUNMessage
+	}
+	size := int(binary.BigEndian.Uint16(s.buf[2:4]))
+	if size%4 != 0 {
+		return nil, ErrNotASTUNMessage
+	}
+	n := headerSize + size
+	if n > len(s.buf) {
+		buf := make([]byte, n)
+		copy(buf, s.buf[:headerSize])
+		s.buf = buf
+	}
+	if _, err := io.ReadFull(s.r, s.buf[headerSize:n]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return s.buf[:n:n], nil
+}
+
+// streamConn is a TCP, TLS or DTLS connection to a server, shared by all the Client's transactions with it.
+type streamConn struct {
+	conn net.Conn
+	t    Transport
+	// done is closed once the connection fails, with err the reason
+	done chan struct{}
+	err  error
+}
+
+// isStreamAddr reports whether addr is reached over a stream transport.
+func isStreamAddr(addr net.Addr) bool {
+	switch addr.(type) {
+	case *net.TCPAddr, *TLSAddr:
+		return true
+	}
+	return false
+}
+
+// isConnAddr reports whether addr is reached over a connection dialed by the Client, rather than its
+// net.PacketConn.
+func isConnAddr(addr net.Addr) bool {
+	_, ok := addr.(*DTLSAddr)
+	return ok || isStreamAddr(addr)
+}
+
+// stream returns the connection to addr, dialing it if not already connected.
+func (c *Client) stream(ctx context.Context, addr net.Addr) (*streamConn, error) {
+	key := addr.Network() + " " + addr.String()
+	switch a := addr.(type) {
+	case *TLSAddr:
+		key += " " + a.ServerName
+	case *DTLSAddr:
+		key += " " + a.ServerName
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClientClosed
+	}
+	sc, ok := c.streams[key]
+	c.mu.Unlock()
+	if ok {
+		return sc, nil
+	}
+
+	d := c.Dialer
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	var conn net.Conn
+	var err error
+	switch a := addr.(type) {
+	case *TLSAddr:
+		td := tls.Dialer{NetDialer: d, Config: c.tlsConfig(a)}
+		conn, err = td.DialContext(ctx, "tcp", a.String())
+	case *DTLSAddr:
+		conn, err = c.dialDTLS(ctx, d, a)
+	default:
+		conn, err = d.DialContext(ctx, "tcp", addr.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := NewStreamTransport(conn)
+	if !isStreamAddr(addr) {
+		t = NewDatagramTransport(conn)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		conn.Close()
+		return nil, ErrClientClosed
+	}
+	// Lost a race to dial the same server
+	if sc, ok := c.streams[key]; ok {
+		conn.Close()
+		return sc, nil
+	}
+	sc = &streamConn{conn: conn, t: t, done: make(chan struct{})}
+	c.streams[key] = sc
+	go c.streamReadLoop(key, sc)
+	return sc, nil
+}
+
+// streamReadLoop routes responses received on sc, until reading fails or the Client is closed.
+func (c *Client) streamReadLoop(key string, sc *streamConn) {
+	defer close(sc.done)
+	for {
+		in, from, local, err := sc.t.ReadMessage()
+		if err != nil {
+			sc.conn.Close()
+			c.mu.Lock()
+			if c.streams[key] == sc {
+				delete(c.streams, key)
+			}
+			if c.closed {
+				err = ErrClientClosed
+			}
+			c.mu.Unlock()
+			sc.err = err
+			return
+		}
+		c.route(in, from, local, sc)
+	}
+}
+
+// isDialError reports whether err is from failing to dial a connection.
+func isDialError(err error) bool {
+	oe, ok := err.(*net.OpError)
+	return ok && oe.Op == "dial"
+}
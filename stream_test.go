@@ -0,0 +1,156 @@
+package stun
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestStreamReader(t *testing.T) {
+	var stream []byte
+
+	for i := byte(0); i < 3; i++ {
+		b := New(TypeBindingRequest, TxID{i})
+		b.SetSoftware(string(bytes.Repeat([]byte{'x'}, int(i))))
+		raw, err := b.Build()
+		if err != nil {
+			t.Fatalf("build failed: %v", err)
+		}
+		stream = append(stream, raw...)
+	}
+
+	var p Parser
+	var m Message
+	sr := NewStreamReader(iotest.OneByteReader(bytes.NewReader(stream)))
+	for i := byte(0); i < 3; i++ {
+		in, err := sr.ReadMessage()
+		if err != nil {
+			t.Fatalf("read %d failed: %v", i, err)
+		}
+		if err := p.Parse(&m, in); err != nil {
+			t.Fatalf("parse %d failed: %v", i, err)
+		}
+		if m.TxID() != (TxID{i}) || len(m.Software()) != int(i) {
+			t.Fatalf("unexpected message %d", i)
+		}
+	}
+	if _, err := sr.ReadMessage(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+
+	sr = NewStreamReader(bytes.NewReader(stream[:len(stream)-1]))
+	sr.ReadMessage()
+	sr.ReadMessage()
+	if _, err := sr.ReadMessage(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF for truncated message, got %v", err)
+	}
+
+	garbage := append([]byte("GET / HTTP/1.1\r\n\r\n"), stream...)
+	if _, err := NewStreamReader(bytes.NewReader(garbage)).ReadMessage(); err != ErrNotASTUNMessage {
+		t.Fatalf("expected ErrNotASTUNMessage, got %v", err)
+	}
+}
+
+func TestStreamReaderLargeMessage(t *testing.T) {
+	raw := newHeader(nil, TypeBindingRequest, txID)
+	raw = appendAttribute(raw, Attr(0x8050), make([]byte, 2*maxMessageSize))
+	setAttrSize(raw)
+
+	in, err := NewStreamReader(bytes.NewReader(raw)).ReadMessage()
+	if err != nil || !bytes.Equal(in, raw) {
+		t.Fatalf("expected %d byte message, got %v", len(raw), err)
+	}
+}
+
+// listenTCPTest returns a loopback TCP listener.
+func listenTCPTest(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	return l
+}
+
+func TestClientTCP(t *testing.T) {
+	var s Server
+	l := listenTCPTest(t)
+	addr, shutdown := serveTest(t, &s, l.Addr(), func(ctx context.Context) error { return s.ServeListener(ctx, l) })
+	defer shutdown()
+
+	c := NewClient(nil)
+	defer c.Close()
+	for i := 0; i < 3; i++ {
+		r, err := c.Binding(context.Background(), addr)
+		if err != nil {
+			t.Fatalf("binding failed: %v", err)
+		}
+		if len(c.streams) != 1 {
+			t.Fatalf("expected a single reused connection, got %d", len(c.streams))
+		}
+		var sc *streamConn
+		for _, sc = range c.streams {
+		}
+		local := sc.conn.LocalAddr().(*net.TCPAddr)
+		if ip, port, ok := r.XorMappedAddress(); !ok || !ip.Equal(local.IP) || int(port) != local.Port {
+			t.Fatalf("expected xor mapped address %v, got %v:%d", local, ip, port)
+		}
+	}
+
+	if _, err := c.Binding(context.Background(), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 3478}); err != ErrUnsupportedTransport {
+		t.Fatalf("expected ErrUnsupportedTransport without a PacketConn, got %v", err)
+	}
+}
+
+func TestClientTCPTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	c := NewClient(nil)
+	defer c.Close()
+	c.Ti = 50 * time.Millisecond
+	if _, err := c.Binding(context.Background(), l.Addr()); err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestClientTCPConnectionClosed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		NewStreamReader(conn).ReadMessage()
+		conn.Close()
+	}()
+
+	c := NewClient(nil)
+	defer c.Close()
+	if _, err := c.Binding(context.Background(), l.Addr()); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+	if n := len(c.streams); n != 0 {
+		t.Fatalf("expected closed connection to be forgotten, got %d", n)
+	}
+}
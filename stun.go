@@ -1,7 +1,9 @@
 package stun
 
 import (
+	"context"
 	"net"
+	"strconv"
 )
 
 const (
@@ -13,52 +15,88 @@ const (
 	nonceSecurityFeaturesPrefix = "obMatJos2"
 )
 
+// Type is the STUN message type, an interleaving of the Method and Class.
+// See https://tools.ietf.org/html/rfc8489#section-5
 type Type uint16
 
 const (
-	TypeBindingRequest Type = 0x0001
-	TypeBindingSuccess Type = 0x0101
+	TypeBindingRequest    Type = 0x0001
+	TypeBindingIndication Type = 0x0011
+	TypeBindingSuccess    Type = 0x0101
+	TypeBindingError      Type = 0x0111
 )
 
-type TxID [12]byte
+// Method is the 12 bit STUN method.
+type Method uint16
+
+const (
+	MethodBinding Method = 0x001
+)
+
+// Class is the 2 bit STUN message class.
+type Class uint8
 
-type Message struct {
-	typ  Type
-	txID TxID
+const (
+	ClassRequest    Class = 0x00
+	ClassIndication Class = 0x01
+	ClassSuccess    Class = 0x02
+	ClassError      Class = 0x03
+)
+
+func (m Method) String() string {
+	if m == MethodBinding {
+		return "Binding"
+	}
+	return "0x" + strconv.FormatUint(uint64(m), 16)
 }
 
-func (m *Message) Type() Type        { return m.typ }
-func (m *Message) TxID() (txID TxID) { copy(txID[:], m.txID[:]); return }
-func (m *Message) Reset() {
-	m.typ = 0
-	for i := range m.txID[:] {
-		m.txID[i] = 0
+func (c Class) String() string {
+	switch c {
+	case ClassRequest:
+		return "request"
+	case ClassIndication:
+		return "indication"
+	case ClassSuccess:
+		return "success response"
+	case ClassError:
+		return "error response"
 	}
+	return "0x" + strconv.FormatUint(uint64(c), 16)
+}
+
+// NewType composes a Type from a Method and Class.
+//
+//	 0                 1
+//	 2  3  4 5 6 7 8 9 0 1 2 3 4 5
+//	+--+--+-+-+-+-+-+-+-+-+-+-+-+-+
+//	|M |M |M|M|M|C|M|M|M|C|M|M|M|M|
+//	|11|10|9|8|7|1|6|5|4|0|3|2|1|0|
+//	+--+--+-+-+-+-+-+-+-+-+-+-+-+-+
+func NewType(m Method, c Class) Type {
+	return Type(m&0x000F | (m&0x0070)<<1 | (m&0x0F80)<<2 | Method(c&0x01)<<4 | Method(c&0x02)<<7)
+}
+
+// Method returns the method of the message type.
+func (t Type) Method() Method {
+	return Method(t&0x000F | (t&0x00E0)>>1 | (t&0x3E00)>>2)
+}
+
+// String returns the method and class, eg "Binding request".
+func (t Type) String() string {
+	return t.Method().String() + " " + t.Class().String()
 }
 
+// Class returns the class of the message type.
+func (t Type) Class() Class {
+	return Class((t&0x0010)>>4 | (t&0x0100)>>7)
+}
+
+type TxID [12]byte
+
+// Serve answers Binding requests received on pc, until reading from pc fails.
+//
+// Deprecated: Use Server.Serve, which supports shutdown, handlers and authentication. password is ignored, as it
+// always has been.
 func Serve(pc net.PacketConn, password string) {
-	buf := make([]byte, 4*1024)
-
-	var p Parser
-	var m Message
-
-	for {
-		n, addr, err := pc.ReadFrom(buf)
-		if err != nil {
-			continue
-		}
-		if err := p.Parse(&m, buf[:n:n]); err != nil {
-			continue
-		}
-		switch m.Type() {
-		case TypeBindingRequest:
-			b := New(TypeBindingSuccess, m.TxID())
-			b.SetXorMappingAddress(addr.(*net.UDPAddr))
-			if raw, err := b.Build(); err == nil {
-				if _, err := pc.WriteTo(raw, addr); err != nil {
-					// @TODO?
-				}
-			}
-		}
-	}
+	(&Server{}).Serve(context.Background(), pc)
 }
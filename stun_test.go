@@ -0,0 +1,37 @@
+package stun
+
+import (
+	"testing"
+)
+
+func TestTypeMethodClass(t *testing.T) {
+	tests := []struct {
+		typ    Type
+		method Method
+		class  Class
+	}{
+		{typ: TypeBindingRequest, method: MethodBinding, class: ClassRequest},
+		{typ: TypeBindingIndication, method: MethodBinding, class: ClassIndication},
+		{typ: TypeBindingSuccess, method: MethodBinding, class: ClassSuccess},
+		{typ: TypeBindingError, method: MethodBinding, class: ClassError},
+		{typ: 0x0003, method: 0x003, class: ClassRequest},    // TURN Allocate request
+		{typ: 0x0113, method: 0x003, class: ClassError},      // TURN Allocate error response
+		{typ: 0x3EEF, method: 0xFFF, class: ClassRequest},    // all method bits
+		{typ: 0x3FFF, method: 0xFFF, class: ClassError},      // all bits
+		{typ: 0x0110, method: 0x000, class: ClassError},      // no method bits
+		{typ: 0x0016, method: 0x006, class: ClassIndication}, // TURN Send indication
+		{typ: 0x0C40, method: 0x320, class: ClassRequest},    // high method bits
+		{typ: 0x0102, method: 0x002, class: ClassSuccess},    // RFC 3489 Shared Secret success
+	}
+	for _, tt := range tests {
+		if m := tt.typ.Method(); m != tt.method {
+			t.Errorf("type %#04x: expected method %#03x, got %#03x", tt.typ, tt.method, m)
+		}
+		if c := tt.typ.Class(); c != tt.class {
+			t.Errorf("type %#04x: expected class %d, got %d", tt.typ, tt.class, c)
+		}
+		if typ := NewType(tt.method, tt.class); typ != tt.typ {
+			t.Errorf("expected type %#04x, got %#04x", tt.typ, typ)
+		}
+	}
+}
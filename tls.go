@@ -0,0 +1,49 @@
+package stun
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// ALPN protocol identifiers.
+// See https://tools.ietf.org/html/rfc7443#section-6
+const (
+	ALPNTURN         = "stun.turn"
+	ALPNNATDiscovery = "stun.nat-discovery"
+)
+
+// TLSAddr is the address of a server reached over TLS, with the name its certificate is validated against.
+type TLSAddr struct {
+	net.TCPAddr
+	ServerName string
+}
+
+func (a *TLSAddr) Network() string { return "tls" }
+func (a *TLSAddr) String() string  { return a.TCPAddr.String() }
+
+// ServeTLS accepts TLS connections from l, see ServeListener. config must contain at least one certificate. If
+// config.NextProtos is empty ALPNNATDiscovery and ALPNTURN are offered.
+// See https://tools.ietf.org/html/rfc8489#section-6.2.3
+func (s *Server) ServeTLS(ctx context.Context, l net.Listener, config *tls.Config) error {
+	config = config.Clone()
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{ALPNNATDiscovery, ALPNTURN}
+	}
+	return s.ServeListener(ctx, tls.NewListener(l, config))
+}
+
+// tlsConfig returns the configuration for a TLS connection to addr.
+func (c *Client) tlsConfig(addr *TLSAddr) *tls.Config {
+	config := &tls.Config{}
+	if c.TLSConfig != nil {
+		config = c.TLSConfig.Clone()
+	}
+	if addr.ServerName != "" {
+		config.ServerName = addr.ServerName
+	}
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{ALPNNATDiscovery}
+	}
+	return config
+}
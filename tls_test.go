@@ -0,0 +1,148 @@
+package stun
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCertificate generates a self signed certificate for the names and 127.0.0.1, returning it and a pool
+// trusting it.
+func testCertificate(t *testing.T, names ...string) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("key generation failed: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: names[0]},
+		DNSNames:              names,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("certificate creation failed: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("certificate parse failed: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}, pool
+}
+
+func TestClientTLS(t *testing.T) {
+	cert, pool := testCertificate(t, "stun.example.org")
+
+	var s Server
+	l := listenTCPTest(t)
+	addr, shutdown := serveTest(t, &s, l.Addr(), func(ctx context.Context) error {
+		return s.ServeTLS(ctx, l, &tls.Config{Certificates: []tls.Certificate{cert}})
+	})
+	defer shutdown()
+
+	c := NewClient(nil)
+	defer c.Close()
+	c.TLSConfig = &tls.Config{RootCAs: pool}
+
+	r, err := c.Binding(context.Background(), &TLSAddr{TCPAddr: *addr.(*net.TCPAddr), ServerName: "stun.example.org"})
+	if err != nil {
+		t.Fatalf("binding failed: %v", err)
+	}
+	if _, _, ok := r.XorMappedAddress(); !ok {
+		t.Fatal("expected xor mapped address")
+	}
+	for _, sc := range c.streams {
+		state := sc.conn.(*tls.Conn).ConnectionState()
+		if state.NegotiatedProtocol != ALPNNATDiscovery {
+			t.Fatalf("expected ALPN %q, got %q", ALPNNATDiscovery, state.NegotiatedProtocol)
+		}
+		if state.ServerName != "stun.example.org" {
+			t.Fatalf("expected SNI stun.example.org, got %q", state.ServerName)
+		}
+	}
+
+	if _, err := c.Binding(context.Background(), &TLSAddr{TCPAddr: *addr.(*net.TCPAddr), ServerName: "other.example.org"}); err == nil {
+		t.Fatal("expected certificate validation to fail for other.example.org")
+	}
+}
+
+func TestClientTLSRedirect(t *testing.T) {
+	cert, pool := testCertificate(t, "stun.example.org", "alt.example.org")
+
+	var alternate Server
+	al := listenTCPTest(t)
+	alternateAddr, shutdown := serveTest(t, &alternate, al.Addr(), func(ctx context.Context) error {
+		return alternate.ServeTLS(ctx, al, &tls.Config{Certificates: []tls.Certificate{cert}})
+	})
+	defer shutdown()
+
+	var s Server
+	s.Handle(MethodBinding, redirectHandler(func() *net.UDPAddr {
+		a := alternateAddr.(*net.TCPAddr)
+		return &net.UDPAddr{IP: a.IP, Port: a.Port}
+	}, "alt.example.org"))
+	l := listenTCPTest(t)
+	addr, shutdown := serveTest(t, &s, l.Addr(), func(ctx context.Context) error {
+		return s.ServeTLS(ctx, l, &tls.Config{Certificates: []tls.Certificate{cert}})
+	})
+	defer shutdown()
+
+	c := NewClient(nil)
+	defer c.Close()
+	c.TLSConfig = &tls.Config{RootCAs: pool}
+
+	r, err := c.Binding(context.Background(), &TLSAddr{TCPAddr: *addr.(*net.TCPAddr), ServerName: "stun.example.org"})
+	if err != nil {
+		t.Fatalf("binding failed: %v", err)
+	}
+	if len(r.Redirects) != 1 {
+		t.Fatalf("expected 1 redirect, got %d", len(r.Redirects))
+	}
+	to, ok := r.Redirects[0].To.(*TLSAddr)
+	if !ok || to.ServerName != "alt.example.org" || to.Port != alternateAddr.(*net.TCPAddr).Port {
+		t.Fatalf("expected redirect to alt.example.org over TLS, got %v", r.Redirects[0].To)
+	}
+}
+
+func TestClientBindingURITLS(t *testing.T) {
+	cert, pool := testCertificate(t, "stun.example.org")
+
+	var s Server
+	l := listenTCPTest(t)
+	addr, shutdown := serveTest(t, &s, l.Addr(), func(ctx context.Context) error {
+		return s.ServeTLS(ctx, l, &tls.Config{Certificates: []tls.Certificate{cert}})
+	})
+	defer shutdown()
+
+	c := NewClient(nil)
+	defer c.Close()
+	c.TLSConfig = &tls.Config{RootCAs: pool}
+	c.Resolver = &testResolver{
+		srv: map[string][]*net.SRV{
+			"_stuns._tcp.stun.example.org": {{Target: "host.example.org", Port: uint16(addr.(*net.TCPAddr).Port)}},
+		},
+		host: map[string][]net.IPAddr{
+			"host.example.org": {{IP: net.IPv4(127, 0, 0, 1)}},
+		},
+	}
+	if _, err := c.BindingURI(context.Background(), &URI{Scheme: SchemeSTUNS, Host: "stun.example.org"}); err != nil {
+		t.Fatalf("binding failed: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package stun
+
+import (
+	"net"
+	"time"
+)
+
+// Transport sends and receives STUN messages, over UDP, TCP, TLS or DTLS.
+type Transport interface {
+	// ReadMessage returns the next message received, the transport address it was received from, and the local
+	// transport address it was received on. The message may reference a buffer reused by the next call.
+	ReadMessage() (msg []byte, from net.Addr, local net.Addr, err error)
+	// WriteMessage sends msg to the transport address to. Connection oriented transports send to their peer,
+	// whatever to is.
+	WriteMessage(msg []byte, to net.Addr) error
+	// Reliable reports whether messages are delivered reliably, in which case requests are not retransmitted.
+	// See https://tools.ietf.org/html/rfc8489#section-6.2
+	Reliable() bool
+	// SetReadDeadline sets the deadline for ReadMessage, a deadline in the past unblocks a pending call.
+	SetReadDeadline(t time.Time) error
+}
+
+// packetTransport is an unreliable Transport over a net.PacketConn.
+type packetTransport struct {
+	pc  net.PacketConn
+	buf []byte
+}
+
+// NewPacketTransport returns an unreliable Transport sending and receiving datagrams on pc, such as a UDP socket.
+func NewPacketTransport(pc net.PacketConn) Transport {
+	return &packetTransport{pc: pc, buf: make([]byte, maxMessageSize)}
+}
+
+func (t *packetTransport) ReadMessage() ([]byte, net.Addr, net.Addr, error) {
+	n, from, err := t.pc.ReadFrom(t.buf)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return t.buf[:n:n], from, t.pc.LocalAddr(), nil
+}
+
+func (t *packetTransport) WriteMessage(msg []byte, to net.Addr) error {
+	_, err := t.pc.WriteTo(msg, to)
+	return err
+}
+
+func (t *packetTransport) Reliable() bool                    { return false }
+func (t *packetTransport) SetReadDeadline(d time.Time) error { return t.pc.SetReadDeadline(d) }
+
+// streamTransport is a reliable Transport over a byte stream connection.
+type streamTransport struct {
+	conn net.Conn
+	sr   *StreamReader
+}
+
+// NewStreamTransport returns a reliable Transport over a byte stream connection such as TCP or TLS, framing
+// messages with StreamReader.
+// See https://tools.ietf.org/html/rfc8489#section-6.2.2
+func NewStreamTransport(conn net.Conn) Transport {
+	return &streamTransport{conn: conn, sr: NewStreamReader(conn)}
+}
+
+func (t *streamTransport) ReadMessage() ([]byte, net.Addr, net.Addr, error) {
+	msg, err := t.sr.ReadMessage()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return msg, t.conn.RemoteAddr(), t.conn.LocalAddr(), nil
+}
+
+func (t *streamTransport) WriteMessage(msg []byte, to net.Addr) error {
+	_, err := t.conn.Write(msg)
+	return err
+}
+
+func (t *streamTransport) Reliable() bool                    { return true }
+func (t *streamTransport) SetReadDeadline(d time.Time) error { return t.conn.SetReadDeadline(d) }
+
+// datagramTransport is an unreliable Transport over a connection preserving message boundaries.
+type datagramTransport struct {
+	conn net.Conn
+	buf  []byte
+	// idle, if not zero, is the time allowed for each read
+	idle time.Duration
+}
+
+// NewDatagramTransport returns an unreliable Transport over a connection preserving message boundaries, such as
+// DTLS, where each read returns a single message.
+func NewDatagramTransport(conn net.Conn) Transport {
+	return newDatagramTransport(conn, 0)
+}
+
+func newDatagramTransport(conn net.Conn, idle time.Duration) *datagramTransport {
+	return &datagramTransport{conn: conn, buf: make([]byte, maxMessageSize), idle: idle}
+}
+
+func (t *datagramTransport) ReadMessage() ([]byte, net.Addr, net.Addr, error) {
+	if t.idle > 0 {
+		if err := t.conn.SetReadDeadline(time.Now().Add(t.idle)); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	n, err := t.conn.Read(t.buf)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return t.buf[:n:n], t.conn.RemoteAddr(), t.conn.LocalAddr(), nil
+}
+
+func (t *datagramTransport) WriteMessage(msg []byte, to net.Addr) error {
+	_, err := t.conn.Write(msg)
+	return err
+}
+
+func (t *datagramTransport) Reliable() bool                    { return false }
+func (t *datagramTransport) SetReadDeadline(d time.Time) error { return t.conn.SetReadDeadline(d) }
@@ -0,0 +1,92 @@
+package stun
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// reliableTransport claims a packet Transport is reliable.
+type reliableTransport struct {
+	Transport
+}
+
+func (reliableTransport) Reliable() bool { return true }
+
+func TestClientReliableTransport(t *testing.T) {
+	addr, n, stop := lossyServer(t, 1<<30)
+	defer stop()
+
+	pc := listenTest(t)
+	defer pc.Close()
+
+	c := NewClientTransport(reliableTransport{NewPacketTransport(pc)})
+	defer c.Close()
+	c.RTO = time.Millisecond
+	c.Ti = 50 * time.Millisecond
+	start := time.Now()
+	if _, err := c.Binding(context.Background(), addr); err != ErrTimeout {
+		t.Fatalf("expected ErrTimeout, got %v", err)
+	}
+	if d := time.Since(start); d < c.Ti {
+		t.Fatalf("expected timeout after at least %v, got %v", c.Ti, d)
+	}
+	if got := atomic.LoadInt32(n); got != 1 {
+		t.Fatalf("expected 1 request over a reliable transport, got %d", got)
+	}
+}
+
+func TestServeStreamTransport(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+	sconn, ok := <-accepted
+	if !ok {
+		t.Fatal("accept failed")
+	}
+	defer sconn.Close()
+
+	var s Server
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.ServeTransport(context.Background(), NewStreamTransport(sconn)) }()
+
+	c := NewClientTransport(NewStreamTransport(conn))
+	defer c.Close()
+	// Requests to a *net.UDPAddr go over the Client's Transport
+	to := l.Addr().(*net.TCPAddr)
+	r, err := c.Binding(context.Background(), &net.UDPAddr{IP: to.IP, Port: to.Port})
+	if err != nil {
+		t.Fatalf("binding failed: %v", err)
+	}
+	local := conn.LocalAddr().(*net.TCPAddr)
+	if ip, port, ok := r.XorMappedAddress(); !ok || !ip.Equal(local.IP) || int(port) != local.Port {
+		t.Fatalf("expected xor mapped address %s, got %s:%d", local, ip, port)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("shutdown failed: %v", err)
+	}
+	if err := <-errCh; err != ErrServerClosed {
+		t.Fatalf("expected ErrServerClosed, got %v", err)
+	}
+}
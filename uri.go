@@ -0,0 +1,178 @@
+package stun
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Default ports for the STUN and TURN URI schemes.
+// See https://tools.ietf.org/html/rfc8489#section-18.6
+const (
+	DefaultPort       = 3478
+	DefaultSecurePort = 5349
+)
+
+// URI schemes.
+const (
+	SchemeSTUN  = "stun"
+	SchemeSTUNS = "stuns"
+	SchemeTURN  = "turn"
+	SchemeTURNS = "turns"
+)
+
+// Transports of the TURN URI transport parameter.
+const (
+	TransportUDP = "udp"
+	TransportTCP = "tcp"
+)
+
+// URI is a STUN or TURN URI.
+// See https://tools.ietf.org/html/rfc7064 & https://tools.ietf.org/html/rfc7065
+type URI struct {
+	// Scheme is one of SchemeSTUN, SchemeSTUNS, SchemeTURN or SchemeTURNS.
+	Scheme string
+	// Host is a domain name or IP address. IPv6 addresses are not enclosed in brackets.
+	Host string
+	// Port is 0 if the URI did not specify one, see Addr.
+	Port int
+	// Transport is the TURN transport parameter, empty if not specified.
+	Transport string
+}
+
+// ParseURI parses a stun, stuns, turn or turns URI.
+func ParseURI(s string) (*URI, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return nil, ErrInvalidURI
+	}
+	u := &URI{Scheme: strings.ToLower(s[:i])}
+	s = s[i+1:]
+	switch u.Scheme {
+	case SchemeSTUN, SchemeSTUNS, SchemeTURN, SchemeTURNS:
+	default:
+		return nil, ErrUnknownScheme
+	}
+
+	if i := strings.IndexByte(s, '?'); i >= 0 {
+		if u.Scheme == SchemeSTUN || u.Scheme == SchemeSTUNS {
+			return nil, ErrInvalidURI
+		}
+		const transport = "transport="
+		q := s[i+1:]
+		if !strings.HasPrefix(q, transport) || !validTransport(q[len(transport):]) {
+			return nil, ErrInvalidURI
+		}
+		u.Transport = strings.ToLower(q[len(transport):])
+		s = s[:i]
+	}
+
+	host, port := s, ""
+	if strings.HasPrefix(s, "[") {
+		i := strings.IndexByte(s, ']')
+		if i < 0 {
+			return nil, ErrInvalidURI
+		}
+		host, port = s[1:i], s[i+1:]
+		if ip := net.ParseIP(host); ip == nil || ip.To4() != nil {
+			return nil, ErrInvalidURI
+		}
+		if port != "" && port[0] != ':' {
+			return nil, ErrInvalidURI
+		}
+	} else if i := strings.IndexByte(s, ':'); i >= 0 {
+		host, port = s[:i], s[i:]
+	}
+	if !validHost(host) {
+		return nil, ErrInvalidURI
+	}
+	u.Host = host
+	if port != "" {
+		// Port 0 is reserved, and would be indistinguishable from the default port
+		p, err := strconv.ParseUint(port[1:], 10, 16)
+		if err != nil || p == 0 {
+			return nil, ErrInvalidURI
+		}
+		u.Port = int(p)
+	}
+	return u, nil
+}
+
+// validHost reports whether host is an IP address or a plausible domain name, and not empty.
+// See https://tools.ietf.org/html/rfc3986#section-3.2.2
+func validHost(host string) bool {
+	if host == "" {
+		return false
+	}
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	for i := 0; i < len(host); i++ {
+		switch c := host[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("-._~!$&'()*+,;=", c) >= 0:
+		case c == '%':
+			if i+2 >= len(host) || !isHex(host[i+1]) || !isHex(host[i+2]) {
+				return false
+			}
+			i += 2
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validTransport reports whether transport is a non empty sequence of unreserved characters.
+// See https://tools.ietf.org/html/rfc7065#section-3.1
+func validTransport(transport string) bool {
+	if transport == "" {
+		return false
+	}
+	for i := 0; i < len(transport); i++ {
+		switch c := transport[i]; {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("-._~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// Secure reports whether the scheme requires TLS or DTLS.
+func (u *URI) Secure() bool {
+	return u.Scheme == SchemeSTUNS || u.Scheme == SchemeTURNS
+}
+
+// Addr returns the host and port, using the default port for the scheme if the URI did not specify one.
+func (u *URI) Addr() string {
+	port := u.Port
+	if port == 0 {
+		port = DefaultPort
+		if u.Secure() {
+			port = DefaultSecurePort
+		}
+	}
+	return net.JoinHostPort(u.Host, strconv.Itoa(port))
+}
+
+func (u *URI) String() string {
+	s := u.Scheme + ":"
+	if strings.IndexByte(u.Host, ':') >= 0 {
+		s += "[" + u.Host + "]"
+	} else {
+		s += u.Host
+	}
+	if u.Port != 0 {
+		s += ":" + strconv.Itoa(u.Port)
+	}
+	if u.Transport != "" {
+		s += "?transport=" + u.Transport
+	}
+	return s
+}
@@ -0,0 +1,91 @@
+package stun
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseURI(t *testing.T) {
+	tests := []struct {
+		s    string
+		uri  URI
+		addr string
+	}{
+		{"stun:example.org", URI{Scheme: SchemeSTUN, Host: "example.org"}, "example.org:3478"},
+		{"stuns:example.org", URI{Scheme: SchemeSTUNS, Host: "example.org"}, "example.org:5349"},
+		{"stun:192.0.2.1:1234", URI{Scheme: SchemeSTUN, Host: "192.0.2.1", Port: 1234}, "192.0.2.1:1234"},
+		{"stun:[2001:db8::1]", URI{Scheme: SchemeSTUN, Host: "2001:db8::1"}, "[2001:db8::1]:3478"},
+		{"stuns:[2001:db8::1]:443", URI{Scheme: SchemeSTUNS, Host: "2001:db8::1", Port: 443}, "[2001:db8::1]:443"},
+		{"turn:example.org?transport=tcp", URI{Scheme: SchemeTURN, Host: "example.org", Transport: TransportTCP}, "example.org:3478"},
+		{"turns:example.org:5350?transport=udp", URI{Scheme: SchemeTURNS, Host: "example.org", Port: 5350, Transport: TransportUDP}, "example.org:5350"},
+	}
+	for _, tt := range tests {
+		u, err := ParseURI(tt.s)
+		if err != nil {
+			t.Fatalf("parse %q failed: %v", tt.s, err)
+		}
+		if *u != tt.uri {
+			t.Fatalf("parse %q expected %+v, got %+v", tt.s, tt.uri, *u)
+		}
+		if a := u.Addr(); a != tt.addr {
+			t.Fatalf("%q expected address %q, got %q", tt.s, tt.addr, a)
+		}
+		if s := u.String(); s != tt.s {
+			t.Fatalf("expected %q, got %q", tt.s, s)
+		}
+	}
+
+	if u, err := ParseURI("STUN:example.org"); err != nil || u.Scheme != SchemeSTUN {
+		t.Fatalf("expected case insensitive scheme, got %v", err)
+	}
+	for _, s := range []string{
+		"example.org",
+		"stun:",
+		"stun://example.org",
+		"stun:example.org:port",
+		"stun:example.org:65536",
+		"stun:example.org:0",
+		"stun:[2001:db8::1]:0",
+		"stun:example.org?transport=udp",
+		"stun:[2001:db8::1",
+		"stun:[192.0.2.1]",
+		"stun:[2001:db8::1]1234",
+		"turn:example.org?transport=",
+		"turn:example.org?foo=bar",
+	} {
+		if _, err := ParseURI(s); err != ErrInvalidURI {
+			t.Fatalf("expected ErrInvalidURI parsing %q, got %v", s, err)
+		}
+	}
+	if _, err := ParseURI("http://example.org"); err != ErrUnknownScheme {
+		t.Fatalf("expected ErrUnknownScheme, got %v", err)
+	}
+}
+
+func TestClientBindingURI(t *testing.T) {
+	var s Server
+	addr, shutdown := serveUDPTest(t, &s)
+	defer shutdown()
+
+	pc := listenTest(t)
+	defer pc.Close()
+
+	c := NewClient(pc)
+	defer c.Close()
+	u := &URI{Scheme: SchemeSTUN, Host: "127.0.0.1", Port: addr.(*net.UDPAddr).Port}
+	if _, err := c.BindingURI(context.Background(), u); err != nil {
+		t.Fatalf("binding %s failed: %v", u, err)
+	}
+	u, err := ParseURI("turns:127.0.0.1:" + strconv.Itoa(addr.(*net.UDPAddr).Port) + "?transport=udp")
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	// DTLS handshake with a server not speaking DTLS
+	c.Ti = 200 * time.Millisecond
+	if _, err := c.BindingURI(context.Background(), u); err == nil {
+		t.Fatalf("expected DTLS handshake to fail")
+	}
+}